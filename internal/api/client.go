@@ -0,0 +1,120 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"identity_provider/internal/util"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultUserAgent is sent with every request unless the client is given a different one
+const DefaultUserAgent = "terraform-provider-identity"
+
+// Client is the provider meta passed to every CRUD function. All calls to the Identity API go through it so that
+// they share one connection pool and one bearer token for the whole run.
+type Client struct {
+	// BaseURL is the root of the Identity API, e.g. https://id.example.com/api/
+	BaseURL string
+	// UserAgent identifies the provider to the API
+	UserAgent string
+	// Tokens supplies the bearer token attached to each request
+	Tokens *util.TokenSource
+	// HTTPClient is used for every request made to the API
+	HTTPClient *http.Client
+	// IgnoredPropertyKeys are account property keys that are never read into state, on top of the built-ins
+	IgnoredPropertyKeys []string
+}
+
+// NewClient returns a client for the API rooted at baseURL. Requests are authenticated using tokens and sent
+// through httpClient.
+func NewClient(baseURL string, tokens *util.TokenSource, httpClient *http.Client) *Client {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	return &Client{
+		BaseURL:    baseURL,
+		UserAgent:  DefaultUserAgent,
+		Tokens:     tokens,
+		HTTPClient: httpClient,
+	}
+}
+
+// Build a request for the given path relative to the base URL. If body is not nil, it is sent as JSON.
+// The request is abandoned if ctx is cancelled or its deadline passes.
+func (c *Client) newRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("User-Agent", c.UserAgent)
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	return request, nil
+}
+
+// Attach a bearer token to the request and send it. If the API rejects the token, it is discarded and the
+// request is sent once more with a freshly issued one.
+func (c *Client) do(request *http.Request) (*http.Response, error) {
+	auth, err := c.Tokens.Token(request.Context())
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+auth)
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusUnauthorized {
+		return response, nil
+	}
+	response.Body.Close()
+
+	// Token was revoked or expired early. Get a new one and retry.
+	c.Tokens.Invalidate()
+	auth, err = c.Tokens.Token(request.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	retry := request.Clone(request.Context())
+	if request.GetBody != nil {
+		retry.Body, err = request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+auth)
+
+	return c.HTTPClient.Do(retry)
+}
+
+// Read the JSON body of a response into out and close the body
+func decodeBody(response *http.Response, out interface{}) error {
+	defer response.Body.Close()
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// Drain and close the body of a response we don't need anything from so the connection can be reused
+func discardBody(response *http.Response) {
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+}
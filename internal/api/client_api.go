@@ -3,13 +3,13 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"identity_provider/internal/structs"
 	"identity_provider/internal/util"
 	"log"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 )
@@ -18,43 +18,32 @@ import (
 //
 // param client the client to create
 //
-// param m: A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // returns the id of the client and nil on success or some error on failure
-func CreateClient(client *structs.Client, m map[string]string) (string, error) {
-	auth, err := util.GetIdenAuth(m)
+func CreateClient(ctx context.Context, client *structs.Client, m *Client) (string, error) {
+	request, err := m.newRequest(ctx, http.MethodPost, "client", client)
 	if err != nil {
 		return "", err
 	}
 
-	asJSON, err := json.Marshal(client)
-	if err != nil {
-		return "", err
-	}
-
-	url := m["id_api_url"] + "client"
-	request, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(asJSON))
-	if err != nil {
-		return "", err
-	}
-	request.Header.Add("Authorization", "Bearer "+auth)
-	request.Header.Set("Content-Type", "application/json")
-	APIClient := &http.Client{}
-
-	response, err := APIClient.Do(request)
+	response, err := m.do(request)
 	if err != nil {
 		return "", err
 	}
 
 	status := response.StatusCode
 	if status != http.StatusOK {
+		discardBody(response)
 		return "", fmt.Errorf("Identity API returned with status code %d when creating client", status)
 	}
 
 	// Get id of client
 	body := make(map[string]interface{})
-	err = json.NewDecoder(response.Body).Decode(&body)
-	defer response.Body.Close()
+	err = decodeBody(response, &body)
+	if err != nil {
+		return "", err
+	}
 
 	id := body["id"].(float64)
 	log.Printf("! Client id: %v", id)
@@ -67,40 +56,28 @@ func CreateClient(client *structs.Client, m map[string]string) (string, error) {
 //
 // param client the client to create
 //
-// param m: A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // Returns the response to the api call and nil on success or some error on failure
-func UpdateClient(client *structs.Client, m map[string]string) error {
-	auth, err := util.GetIdenAuth(m)
-	if err != nil {
-		return err
+func UpdateClient(ctx context.Context, client *structs.Client, m *Client) error {
+	// URLs and claims without an ID are created by this call, so a retry could leave duplicates behind
+	if hasNewEntries(client) {
+		ctx = util.WithRetry(ctx, false)
 	}
 
-	log.Printf("! Client secrets: %+v", client.Secrets)
-
-	log.Printf("! Client = %+v", client)
-	asJSON, err := json.Marshal(client)
+	request, err := m.newRequest(ctx, http.MethodPut, "client", client)
 	if err != nil {
 		return err
 	}
 
-	url := m["id_api_url"] + "client"
-	request, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(asJSON))
-	if err != nil {
-		return err
-	}
-
-	request.Header.Add("Authorization", "Bearer "+auth)
-	request.Header.Set("Content-Type", "application/json")
-	APIClient := &http.Client{}
-
-	response, err := APIClient.Do(request)
+	response, err := m.do(request)
 	if err != nil {
 		return err
 	}
 
 	status := response.StatusCode
 	if status != http.StatusOK {
+		discardBody(response)
 		return fmt.Errorf("Identity API returned with status code %d when updating client", status)
 	}
 
@@ -115,8 +92,9 @@ func UpdateClient(client *structs.Client, m map[string]string) error {
 	// Setting secrets requires a distinct API call
 	if len(*secrets) > 0 {
 		log.Printf("! Calling addSecrets")
-		err = addSecrets(secrets, auth, strconv.Itoa(int(client.ID)), m["id_api_url"])
+		err = addSecrets(ctx, secrets, strconv.Itoa(int(client.ID)), m)
 		if err != nil {
+			discardBody(response)
 			return err
 		}
 		(*client).Secrets = *secrets
@@ -129,38 +107,33 @@ func UpdateClient(client *structs.Client, m map[string]string) error {
 //
 // param id the of the client to consider
 //
-// param m: A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // returns a client struct and an optional error value
-func ReadClient(id string, m map[string]string) (*structs.Client, error) {
-	auth, err := util.GetIdenAuth(m)
-	if err != nil {
-		return nil, err
-	}
-
+func ReadClient(ctx context.Context, id string, m *Client) (*structs.Client, error) {
 	// Call API and get response with client state
-	url := m["id_api_url"] + "client/" + id
-	request, err := http.NewRequest(http.MethodGet, url, nil)
+	request, err := m.newRequest(ctx, http.MethodGet, "client/"+id, nil)
 	if err != nil {
 		return nil, err
 	}
-	request.Header.Add("Authorization", "Bearer "+auth)
-	APIClient := &http.Client{}
 
-	response, err := APIClient.Do(request)
+	response, err := m.do(request)
 	if err != nil {
 		return nil, err
 	}
 
 	status := response.StatusCode
 	if status != http.StatusOK {
+		discardBody(response)
 		return nil, fmt.Errorf("Identity API returned with status code %d when reading client", status)
 	}
 
 	// Read response
 	body := make(map[string]interface{})
-	err = json.NewDecoder(response.Body).Decode(&body)
-	defer response.Body.Close()
+	err = decodeBody(response, &body)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get top level fields
 	client := &structs.Client{
@@ -234,34 +207,94 @@ func ReadClient(id string, m map[string]string) (*structs.Client, error) {
 	}
 	client.Claims = *claimStructs
 
+	// Grab secrets. The API never shows the value of a secret, so only the metadata is available.
+	// Secrets is left nil if the API doesn't report them at all.
+	secretsGeneric, ok := body["secrets"].([]interface{})
+	if !ok {
+		return client, nil
+	}
+	secretStructs := make([]structs.Secret, 0)
+	for _, sec := range secretsGeneric {
+		asMap := sec.(map[string]interface{})
+		deleted, _ := asMap["deleted"].(bool)
+		if deleted {
+			continue
+		}
+		secretStructs = append(secretStructs, structs.Secret{
+			ID: int(asMap["id"].(float64)),
+		})
+	}
+	client.Secrets = secretStructs
+
 	return client, nil
 }
 
-// ClientExists returns whether a client with a given id exists
+// FindClient returns the ID of the client with the given name. Used when importing a client by name.
 //
-// param id the of the client to consider
+// param name the name of the client
 //
-// param m: A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
-// Returns whether the client exists and an optional error value
-func ClientExists(id string, m map[string]string) (bool, error) {
-	auth, err := util.GetIdenAuth(m)
+// Returns the id of the client and nil on success or some error on failure
+func FindClient(ctx context.Context, name string, m *Client) (string, error) {
+	request, err := m.newRequest(ctx, http.MethodGet, "clients?Term="+url.QueryEscape(name), nil)
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
-	url := m["id_api_url"] + "client/" + id
-	request, err := http.NewRequest(http.MethodGet, url, nil)
+	response, err := m.do(request)
+	if err != nil {
+		return "", err
+	}
+
+	status := response.StatusCode
+	if status != http.StatusOK {
+		discardBody(response)
+		return "", fmt.Errorf("Identity API returned with status code %d when searching for client", status)
+	}
+
+	body := make([]interface{}, 0)
+	err = decodeBody(response, &body)
+	if err != nil {
+		return "", err
+	}
+
+	// The search matches on partial names, so only keep exact matches
+	ids := make([]string, 0)
+	for _, item := range body {
+		asMap := item.(map[string]interface{})
+		if asMap["name"].(string) == name {
+			ids = append(ids, strconv.FormatFloat(asMap["id"].(float64), 'f', -1, 64))
+		}
+	}
+
+	if len(ids) > 1 {
+		return "", fmt.Errorf("Multiple clients exist with the name %v", name)
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("No client found with the name %v", name)
+	}
+	return ids[0], nil
+}
+
+// ClientExists returns whether a client with a given id exists
+//
+// param id the of the client to consider
+//
+// param m: The API client configured for the provider
+//
+// Returns whether the client exists and an optional error value
+func ClientExists(ctx context.Context, id string, m *Client) (bool, error) {
+	request, err := m.newRequest(ctx, http.MethodGet, "client/"+id, nil)
 	if err != nil {
 		return false, err
 	}
-	request.Header.Add("Authorization", "Bearer "+auth)
-	APIClient := &http.Client{}
 
-	response, err := APIClient.Do(request)
+	response, err := m.do(request)
 	if err != nil {
 		return false, err
 	}
+	discardBody(response)
 
 	status := response.StatusCode
 	if status != http.StatusOK {
@@ -275,28 +308,20 @@ func ClientExists(id string, m map[string]string) (bool, error) {
 //
 // param id the of the client to consider
 //
-// param m: A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // Returns nil on success or some error on failure
-func DeleteClient(id string, m map[string]string) error {
-	auth, err := util.GetIdenAuth(m)
+func DeleteClient(ctx context.Context, id string, m *Client) error {
+	request, err := m.newRequest(ctx, http.MethodDelete, "client/"+id, nil)
 	if err != nil {
 		return err
 	}
 
-	url := m["id_api_url"] + "client/" + id
-	request, err := http.NewRequest(http.MethodDelete, url, nil)
-	if err != nil {
-		return err
-	}
-	request.Header.Add("Authorization", "Bearer "+auth)
-	APIClient := &http.Client{}
-
-	log.Printf("! Client delete request: %+v", request)
-	response, err := APIClient.Do(request)
+	response, err := m.do(request)
 	if err != nil {
 		return err
 	}
+	discardBody(response)
 
 	status := response.StatusCode
 	if status != http.StatusOK {
@@ -305,44 +330,57 @@ func DeleteClient(id string, m map[string]string) error {
 	return nil
 }
 
+// Whether a client has URLs or claims that don't exist yet
+func hasNewEntries(client *structs.Client) bool {
+	for _, urls := range [][]structs.URL{client.RedirectURLs, client.PostLogoutURLs, client.CorsURLs} {
+		for _, url := range urls {
+			if url.ID == 0 && !url.Deleted {
+				return true
+			}
+		}
+	}
+	for _, claim := range client.Claims {
+		if claim.ID == 0 && !claim.Deleted {
+			return true
+		}
+	}
+	return false
+}
+
 // Add the specified secrets to the client
-func addSecrets(secrets *[]structs.Secret, auth, clientID, baseURL string) error {
+func addSecrets(ctx context.Context, secrets *[]structs.Secret, clientID string, m *Client) error {
 	log.Printf("! Adding secrets to client with id %v", clientID)
-	log.Printf("! Secrets array: %+v", secrets)
 
 	for i := range *secrets {
 		log.Printf("! Adding a secret")
 
-		url := baseURL + "client/" + clientID + "/secret"
-		request, err := http.NewRequest(http.MethodPut, url, nil)
+		// Each call generates a new secret, so a retry could leave an extra one behind
+		request, err := m.newRequest(util.WithRetry(ctx, false), http.MethodPut, "client/"+clientID+"/secret", nil)
 		if err != nil {
 			return err
 		}
-		request.Header.Add("Authorization", "Bearer "+auth)
-		request.Header.Set("Content-Type", "application/json")
-		APIClient := &http.Client{}
 
-		response, err := APIClient.Do(request)
+		response, err := m.do(request)
 		if err != nil {
 			return err
 		}
 
 		status := response.StatusCode
 		if status != http.StatusOK {
+			discardBody(response)
 			return fmt.Errorf("Identity API returned with status code %d when adding secret", status)
 		}
 
 		// Read secret properties from resp body
 		body := make(map[string]interface{})
-		err = json.NewDecoder(response.Body).Decode(&body)
+		err = decodeBody(response, &body)
 		if err != nil {
 			return err
 		}
-		defer response.Body.Close()
 		(*secrets)[i].ID = int(body["id"].(float64))
 		(*secrets)[i].Value = body["value"].(string)
 		(*secrets)[i].Deleted = body["deleted"].(bool)
-		log.Printf("! Added secret: %+v", (*secrets)[i])
+		log.Printf("! Added secret with id %v", (*secrets)[i].ID)
 	}
 
 	return nil
@@ -351,11 +389,10 @@ func addSecrets(secrets *[]structs.Secret, auth, clientID, baseURL string) error
 // Get the IDs of URLs, secrets, and managers. Set them in the passed client pointer
 func readNestedIDs(client *structs.Client, resp *http.Response) error {
 	body := make(map[string]interface{})
-	err := json.NewDecoder(resp.Body).Decode(&body)
+	err := decodeBody(resp, &body)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
 	// Sort struct fields so they can be corresponded to remote state
 	client.SortFields()
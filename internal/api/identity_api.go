@@ -3,100 +3,134 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"identity_provider/internal/structs"
-	"identity_provider/internal/util"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 )
 
+// Number of accounts requested at a time when searching
+const accountPageSize = 100
+
+// Account statuses returned by the API
+const (
+	StatusEnabled  = "Enabled"
+	StatusDisabled = "Disabled"
+)
+
+// BuiltinPropertyKeys are the keys of the properties Identity sets on every account by itself. They are not
+// managed through the property blocks of an account.
+var BuiltinPropertyKeys = []string{"name", "username", "email"}
+
+// IsBuiltinProperty returns whether a property key belongs to one of the properties Identity sets by itself
+func IsBuiltinProperty(key string) bool {
+	for _, builtin := range BuiltinPropertyKeys {
+		if strings.EqualFold(key, builtin) {
+			return true
+		}
+	}
+	return false
+}
+
 // CreateAccount creates a new identity account with the given parameters
 //
 // param acct: A struct containing info on the account to create
 //
-// param m: A A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // Returns bool stating if this account is unique and an optional error value
-func CreateAccount(acct *structs.Account, m map[string]string) (bool, error) {
-	auth, err := util.GetIdenAuth(m)
-	if err != nil {
-		return true, err
-	}
-
-	asJSON, err := json.Marshal(acct)
+func CreateAccount(ctx context.Context, acct *structs.Account, m *Client) (bool, error) {
+	_, exists, err := CreateAccounts(ctx, acct, m)
 	if err != nil {
 		return true, err
 	}
+	return exists[0], nil
+}
 
-	url := m["id_api_url"] + "account"
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(asJSON))
+// CreateAccounts creates an account for each of the usernames in acct in a single request. All of the accounts get
+// the same password and role.
+//
+// param acct: A struct containing info on the accounts to create
+//
+// param m: The API client configured for the provider
+//
+// Returns, in the same order as the usernames, a struct holding the ID, global ID and role of each account if the
+// API sent them back, whether each account already existed, and an optional error value
+func CreateAccounts(ctx context.Context, acct *structs.Account, m *Client) ([]*structs.Account, []bool, error) {
+	request, err := m.newRequest(ctx, http.MethodPost, "account", acct)
 	if err != nil {
-		return true, err
+		return nil, nil, err
 	}
-	request.Header.Add("Authorization", "Bearer "+auth)
-	request.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
 
-	response, err := client.Do(request)
+	response, err := m.do(request)
 	if err != nil {
-		return true, err
+		return nil, nil, err
 	}
 
-	log.Printf("! Create account url: %v", url)
-	log.Printf("! Create account response: %+v", response)
-
 	status := response.StatusCode
 	if status != http.StatusOK {
-		return true, fmt.Errorf("Identity API returned with status code %d when creating account", status)
+		discardBody(response)
+		return nil, nil, fmt.Errorf("Identity API returned with status code %d when creating account", status)
 	}
 
-	// Check for a message saying this account is not unique. If it's there, just re-enable the account.
+	// The API answers with one entry per username
 	bodyArr := new([]interface{})
-	err = json.NewDecoder(response.Body).Decode(bodyArr)
-	body := (*bodyArr)[0]
-	defer response.Body.Close()
-
-	asMap := body.(map[string]interface{})
-	log.Printf("! Resp body from account creation: %+v", asMap)
-	if asMap["message"] != nil {
-		if asMap["message"].(string) == "AccountNotUnique" {
-			return true, nil
+	err = decodeBody(response, bodyArr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(*bodyArr) != len(acct.Usernames) {
+		return nil, nil, fmt.Errorf("Identity API returned %d results when creating %d accounts", len(*bodyArr), len(acct.Usernames))
+	}
+
+	accounts := make([]*structs.Account, len(acct.Usernames))
+	exists := make([]bool, len(acct.Usernames))
+	for i, body := range *bodyArr {
+		asMap := body.(map[string]interface{})
+
+		created := &structs.Account{Usernames: []string{acct.Usernames[i]}}
+		if id, ok := asMap["id"].(float64); ok {
+			created.ID = strconv.FormatFloat(id, 'f', -1, 64)
 		}
+		created.GlobalID, _ = asMap["globalId"].(string)
+		created.Role, _ = asMap["role"].(string)
+		accounts[i] = created
+
+		// Check for a message saying this account is not unique. If it's there, the caller can re-enable it.
+		if message, _ := asMap["message"].(string); message == "AccountNotUnique" {
+			exists[i] = true
+		}
+		log.Printf("! Account creation returned ID %v, already existed: %v", created.ID, exists[i])
 	}
 
-	return false, nil
+	return accounts, exists, nil
 }
 
 // GetIDs returns the Id and globalID of an account, along with an error value
 //
-// param term the account to consider
+// param term the username of the account. Only accounts with exactly this username, ignoring case, are considered
 //
-// param m: A A map containing configuration info for the provider
-func GetIDs(term string, m map[string]string) (string, string, error) {
+// param m: The API client configured for the provider
+func GetIDs(ctx context.Context, term string, m *Client) (string, string, error) {
 	log.Printf("Getting IDs for account with username %s", term)
-	response, err := getAccount(term, m)
+	body, err := getAccountsByUsername(ctx, term, m)
 	if err != nil {
 		return "", "", err
 	}
 
-	log.Printf("! response: %+v", response)
-	// Read data from response
-	body := new([]interface{})
-	err = json.NewDecoder(response.Body).Decode(body)
-	defer response.Body.Close()
-
-	if len(*body) > 1 {
+	if len(body) > 1 {
 		return "", "", fmt.Errorf("Error retrieving account IDs. Multiple accounts exist with the term %v", term)
 	}
 
-	if len(*body) == 0 {
+	if len(body) == 0 {
 		return "", "", fmt.Errorf("No accounts found with term %v", term)
 	}
 
-	asMap := (*body)[0].(map[string]interface{})
+	asMap := body[0]
 
 	id := strconv.FormatFloat(asMap["id"].(float64), 'f', -1, 64)
 	return id, asMap["globalId"].(string), nil
@@ -106,213 +140,279 @@ func GetIDs(term string, m map[string]string) (string, string, error) {
 //
 // param term the username of the account
 //
-// param m: A A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // Returns true iff the account is active and an optional error value
-func IsActive(term string, m map[string]string) (bool, error) {
-	response, err := getAccount(term, m)
+func IsActive(ctx context.Context, term string, m *Client) (bool, error) {
+	body, err := getAccountsByUsername(ctx, term, m)
 	if err != nil {
 		return false, err
 	}
 
-	// Read data from response
-	body := new([]interface{})
-	err = json.NewDecoder(response.Body).Decode(body)
-	defer response.Body.Close()
-
-	if len(*body) < 1 {
+	if len(body) < 1 {
 		return false, nil
 	}
 
-	asMap := (*body)[0].(map[string]interface{})
+	asMap := body[0]
 
-	return asMap["status"].(string) == "Enabled", nil
+	return asMap["status"].(string) == StatusEnabled, nil
 }
 
 // ReadAccount returns a struct representation of a given account.
 //
 // param term the username of the account
 //
-// param m: A A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // Returns an account struct and an optional error
-func ReadAccount(term string, m map[string]string) (*structs.Account, error) {
+func ReadAccount(ctx context.Context, term string, m *Client) (*structs.Account, error) {
 	log.Printf("! Calling read API function")
-	response, err := getAccount(term, m)
+	body, err := getAccountsByUsername(ctx, term, m)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("! Back in ReadAccount after calling getAccount")
-	// Read data from response
-	body := new([]interface{})
-	err = json.NewDecoder(response.Body).Decode(body)
-	defer response.Body.Close()
+	if len(body) == 0 {
+		return nil, fmt.Errorf("No accounts found with term %v", term)
+	}
 
-	asMap := (*body)[0].(map[string]interface{})
-	props := asMap["properties"].([]interface{})
-	// Email is always the third property
-	propMap := props[2].(map[string]interface{})
-	user := propMap["value"].(string)
+	acct := accountFromMap(body[0])
 
-	acct := &structs.Account{
-		Usernames: []string{user},
-		Role:      asMap["role"].(string),
-		Status:    asMap["status"].(string),
-		ID:        strconv.FormatFloat(asMap["id"].(float64), 'f', -1, 64),
-		GlobalID:  asMap["globalId"].(string),
+	log.Printf("! Returning account with ID %v and status %v", acct.ID, acct.Status)
+	return acct, nil
+
+}
+
+// ReadAccountByID returns a struct representation of the account with the given ID.
+//
+// param id the ID of the account
+//
+// param m: The API client configured for the provider
+//
+// Returns an account struct, or nil if there is no account with this ID, and an optional error
+func ReadAccountByID(ctx context.Context, id string, m *Client) (*structs.Account, error) {
+	body, err := getAccountByID(ctx, id, m)
+	if err != nil || body == nil {
+		return nil, err
 	}
 
-	log.Printf("! Returning account struct: %+v", acct)
-	return acct, nil
+	return accountFromMap(body), nil
+}
+
+// FindAccount looks up a single account for import. The term can be the account's ID, its global ID, or one of
+// its usernames. A numeric term is fetched directly by ID first. Other terms, and IDs with no account, are searched
+// for, and accounts returned by the search that don't match the term exactly are ignored.
+//
+// param term the ID, global ID, or username of the account
+//
+// param m: The API client configured for the provider
+//
+// Returns an account struct and an optional error
+func FindAccount(ctx context.Context, term string, m *Client) (*structs.Account, error) {
+	if _, err := strconv.Atoi(term); err == nil {
+		acct, err := ReadAccountByID(ctx, term, m)
+		if err != nil {
+			return nil, err
+		}
+		if acct != nil {
+			return acct, nil
+		}
+	}
+
+	body, err := getAccount(ctx, term, m)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := new([]*structs.Account)
+	for _, item := range body {
+		asMap := item.(map[string]interface{})
+		if accountMatches(asMap, term) {
+			*matches = append(*matches, accountFromMap(asMap))
+		}
+	}
+
+	if len(*matches) > 1 {
+		return nil, fmt.Errorf("Multiple accounts match %v", term)
+	}
+	if len(*matches) == 0 {
+		return nil, fmt.Errorf("No account found with ID, global ID, or username %v", term)
+	}
+	return (*matches)[0], nil
+}
 
+// SetStatus sets the status of a given account to "Enabled" or "Disabled"
+//
+// param id the ID of the account
+//
+// param status the status to set
+//
+// param m: The API client configured for the provider
+//
+// Returns some error on failure or nil on success
+func SetStatus(ctx context.Context, id, status string, m *Client) error {
+	switch status {
+	case StatusEnabled:
+		return EnableAccount(ctx, id, m)
+	case StatusDisabled:
+		return DisableAccount(ctx, id, m)
+	}
+	return fmt.Errorf("Unsupported account status %v", status)
 }
 
 // DisableAccount sets the status of a given account to inactive.
 //
 // param id the ID of the account to disable
 //
-// param m: A A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // Returns some error on failure or nil on success
-func DisableAccount(id string, m map[string]string) error {
-	auth, err := util.GetIdenAuth(m)
-	if err != nil {
-		return err
-	}
+func DisableAccount(ctx context.Context, id string, m *Client) error {
+	return setState(ctx, id, "disabled", m)
+}
 
-	url := m["id_api_url"] + "account/" + id + "/state/disabled"
+// EnableAccount sets the status of a given account to active.
+//
+// param id the ID of the account to enable
+//
+// param m: The API client configured for the provider
+//
+// Returns some error on failure or nil on success
+func EnableAccount(ctx context.Context, id string, m *Client) error {
+	return setState(ctx, id, "enabled", m)
+}
 
-	request, err := http.NewRequest(http.MethodPut, url, nil)
+// SetRole sets the role of a given account
+//
+// param id the ID of the account
+//
+// param role the role to set
+//
+// param m: The API client configured for the provider
+//
+// Returns some error on failure or nil on success
+func SetRole(ctx context.Context, id, role string, m *Client) error {
+	request, err := m.newRequest(ctx, http.MethodPut, "account/"+id+"/role/"+role, nil)
 	if err != nil {
 		return err
 	}
-	request.Header.Add("Authorization", "Bearer "+auth)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := m.do(request)
 	if err != nil {
 		return err
 	}
+	discardBody(response)
 
 	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("error disabling account %v", id)
+		return fmt.Errorf("error setting role on account %v", id)
 	}
 	return nil
 }
 
-// EnableAccount sets the status of a given account to active.
+// SetPassword replaces the password of a given account
 //
-// param id the ID of the account to enable
+// param id the ID of the account
 //
-// param m: A A map containing configuration info for the provider
+// param password the new password
+//
+// param m: The API client configured for the provider
 //
 // Returns some error on failure or nil on success
-func EnableAccount(id string, m map[string]string) error {
-	auth, err := util.GetIdenAuth(m)
+func SetPassword(ctx context.Context, id, password string, m *Client) error {
+	payload := map[string]string{"value": password}
+	request, err := m.newRequest(ctx, http.MethodPut, "account/"+id+"/password", payload)
 	if err != nil {
 		return err
 	}
 
-	url := m["id_api_url"] + "account/" + id + "/state/enabled"
-
-	request, err := http.NewRequest(http.MethodPut, url, nil)
-	if err != nil {
-		return err
-	}
-	request.Header.Add("Authorization", "Bearer "+auth)
-
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := m.do(request)
 	if err != nil {
 		return err
 	}
+	discardBody(response)
 
 	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("error enabling account %v", id)
+		return fmt.Errorf("Identity API returned with status code %d when changing password on account %v", response.StatusCode, id)
 	}
 	return nil
 }
 
-// SetRole sets the role of a given account
+// AddUsername adds another username to an existing account
 //
 // param id the ID of the account
 //
-// param role the role to set
+// param username the username to add
 //
-// param m: A A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // Returns some error on failure or nil on success
-func SetRole(id, role string, m map[string]string) error {
-	log.Printf("! At top of SetTole")
+func AddUsername(ctx context.Context, id, username string, m *Client) error {
+	payload := map[string]string{"value": username}
+	request, err := m.newRequest(ctx, http.MethodPost, "account/"+id+"/username", payload)
+	if err != nil {
+		return err
+	}
 
-	auth, err := util.GetIdenAuth(m)
+	response, err := m.do(request)
 	if err != nil {
 		return err
 	}
+	discardBody(response)
 
-	url := m["id_api_url"] + "account/" + id + "/role/" + role
-	log.Printf("! URL adding role: %v", url)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Identity API returned with status code %d when adding username %v to account %v", response.StatusCode, username, id)
+	}
+	return nil
+}
 
-	request, err := http.NewRequest(http.MethodPut, url, nil)
+// RemoveUsername removes one of the usernames of an account
+//
+// param id the ID of the account
+//
+// param username the username to remove
+//
+// param m: The API client configured for the provider
+//
+// Returns some error on failure or nil on success
+func RemoveUsername(ctx context.Context, id, username string, m *Client) error {
+	request, err := m.newRequest(ctx, http.MethodDelete, "account/"+id+"/username/"+url.PathEscape(username), nil)
 	if err != nil {
 		return err
 	}
-	request.Header.Add("Authorization", "Bearer "+auth)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := m.do(request)
 	if err != nil {
 		return err
 	}
+	discardBody(response)
 
-	log.Printf("! Response to setting role: %+v", response)
 	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("error setting role on account %v", id)
+		return fmt.Errorf("Identity API returned with status code %d when removing username %v from account %v", response.StatusCode, username, id)
 	}
 	return nil
 }
 
 // AddProperties adds a list of properties to an account
 //
-// param acctID the ID of the account
+// param props the properties to add
 //
-// param prop the property to add
-//
-// param m: A A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // Returns nil on success or some error on failure
-func AddProperties(props *[]*structs.Property, m map[string]string) error {
-	auth, err := util.GetIdenAuth(m)
-	if err != nil {
-		return err
-	}
-
+func AddProperties(ctx context.Context, props *[]*structs.Property, m *Client) error {
 	for i, prop := range *props {
-		log.Printf("! Adding property: %+v", *prop)
-		payload, err := json.Marshal(prop)
-		if err != nil {
-			return err
-		}
+		log.Printf("! Adding property with key %v", prop.Key)
 
-		test := new(map[string]interface{})
-		json.Unmarshal(payload, test)
-		log.Printf("! unmarshaled: %+v", test)
-
-		url := m["id_api_url"] + "account/property"
-		request, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(payload))
+		request, err := m.newRequest(ctx, http.MethodPut, "account/property", prop)
 		if err != nil {
 			return err
 		}
-		request.Header.Add("Authorization", "Bearer "+auth)
-		request.Header.Set("Content-Type", "application/json")
-		client := &http.Client{}
-		log.Printf("! Request: %+v", request)
 
-		response, err := client.Do(request)
+		response, err := m.do(request)
 		if err != nil {
 			return err
 		}
+		discardBody(response)
 
 		status := response.StatusCode
 		if status != http.StatusOK {
@@ -323,78 +423,370 @@ func AddProperties(props *[]*structs.Property, m map[string]string) error {
 	return nil
 }
 
+// RemoveProperties removes a list of properties from an account. The API has no way to delete a property, so
+// each one is left in place with an empty value. ReadProperties skips properties with no value.
+//
+// param props the properties to remove
+//
+// param m: The API client configured for the provider
+//
+// Returns nil on success or some error on failure
+func RemoveProperties(ctx context.Context, props *[]*structs.Property, m *Client) error {
+	blanked := new([]*structs.Property)
+	for _, prop := range *props {
+		log.Printf("! Removing property with key %v", prop.Key)
+		*blanked = append(*blanked, &structs.Property{
+			AccountID: prop.AccountID,
+			Key:       prop.Key,
+			Value:     "",
+		})
+	}
+
+	return AddProperties(ctx, blanked, m)
+}
+
 // ReadProperties reads the proprties associated with a given account.
 //
 // param acct: the id of the account to consider
 //
-// param m: A A map containing configuration info for the provider
+// param m: The API client configured for the provider
 //
 // Returns an array of maps representing proprties and nil on success or some error on failure
-func ReadProperties(acct string, m map[string]string) (*[]map[string]interface{}, error) {
+func ReadProperties(ctx context.Context, acct string, m *Client) (*[]map[string]interface{}, error) {
 	log.Printf("! Calling read properties API function")
-	response, err := getAccount(acct, m)
+	asMap, err := getAccountByID(ctx, acct, m)
 	if err != nil {
 		return nil, err
 	}
-
-	// Read data from response
-	body := new([]interface{})
-	err = json.NewDecoder(response.Body).Decode(body)
-	defer response.Body.Close()
-
-	asMap := (*body)[0].(map[string]interface{})
-	props := asMap["properties"].([]interface{})
-	// The first 3 properties are automatically set and not managed by terraform
-	props = props[3:]
+	if asMap == nil {
+		return nil, fmt.Errorf("No account found with ID %v", acct)
+	}
 
 	// Read each relevant property
 	ret := new([]map[string]interface{})
-	for _, prop := range props {
-		asMap := prop.(map[string]interface{})
-		*ret = append(*ret, map[string]interface{}{
-			"account_id": int(asMap["accountId"].(float64)),
-			"key":        asMap["key"].(string),
-			"value":      asMap["value"].(string),
-		})
+	for _, prop := range managedProperties(asMap, m) {
+		*ret = append(*ret, prop.AsMap())
 	}
 
 	return ret, nil
 
 }
 
-// Call API to get account with the given search term.
-func getAccount(term string, m map[string]string) (*http.Response, error) {
-	log.Printf("! At top of getAccount")
-
-	auth, err := util.GetIdenAuth(m)
+// SearchAccounts returns every account found by the Identity account search for a term, along with the
+// properties of each account that are not built-in or ignored. Results are fetched a page at a time.
+//
+// param term the search term. An empty term returns all accounts
+//
+// param m: The API client configured for the provider
+//
+// Returns a list of account structs and an optional error
+func SearchAccounts(ctx context.Context, term string, m *Client) ([]*structs.Account, error) {
+	ret := make([]*structs.Account, 0)
+	err := pageAccounts(ctx, term, 0, m, func(acct *structs.Account) bool {
+		ret = append(ret, acct)
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
+	return ret, nil
+}
 
-	apiURL := m["id_api_url"] + "accounts" + "?Term=" + term
+// FindAccountsByID looks for the accounts with the given IDs by paging through every account, which takes far
+// fewer requests than reading a large number of accounts one at a time. It stops as soon as all of them are found.
+//
+// param ids the IDs of the accounts to find
+//
+// param maxPages the most pages to read before giving up on the accounts not found yet. Zero means no limit
+//
+// param m: The API client configured for the provider
+//
+// Returns the accounts that were found, keyed by ID, and an optional error
+func FindAccountsByID(ctx context.Context, ids []string, maxPages int, m *Client) (map[string]*structs.Account, error) {
+	wanted := make(map[string]bool)
+	for _, id := range ids {
+		wanted[id] = true
+	}
 
-	request, err := http.NewRequest(http.MethodGet, apiURL, nil)
+	ret := make(map[string]*structs.Account)
+	err := pageAccounts(ctx, "", maxPages, m, func(acct *structs.Account) bool {
+		if wanted[acct.ID] {
+			ret[acct.ID] = acct
+		}
+		return len(ret) < len(wanted)
+	})
 	if err != nil {
-		log.Printf("! Error creating request")
 		return nil, err
 	}
+	return ret, nil
+}
+
+// Page through the accounts found by a search for term and call visit with each one, until visit returns false,
+// maxPages pages were read, or there are no more accounts. A maxPages of zero means no limit.
+func pageAccounts(ctx context.Context, term string, maxPages int, m *Client, visit func(*structs.Account) bool) error {
+	seen := make(map[string]bool)
+	var previous []string
+	for pages, skip := 0, 0; maxPages == 0 || pages < maxPages; pages, skip = pages+1, skip+accountPageSize {
+		query := url.Values{}
+		query.Set("Term", term)
+		query.Set("Skip", strconv.Itoa(skip))
+		query.Set("Take", strconv.Itoa(accountPageSize))
+
+		page, err := searchAccounts(ctx, query, m)
+		if err != nil {
+			return err
+		}
 
-	request.Header.Add("Authorization", "Bearer "+auth)
-	request.Header.Add("Content-Type", "text/plain")
-	log.Printf("! In get account, request is %+v", request)
+		pageIDs := make([]string, 0, len(page))
+		for _, item := range page {
+			asMap := item.(map[string]interface{})
+			acct := accountFromMap(asMap)
+			pageIDs = append(pageIDs, acct.ID)
+			// An account created during the search can push another one onto the next page as well
+			if seen[acct.ID] {
+				continue
+			}
+			seen[acct.ID] = true
+			acct.Properties = managedProperties(asMap, m)
+			if !visit(acct) {
+				return nil
+			}
+		}
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+		// Stop if the API ignored Skip and sent the same page again
+		if len(page) > 0 && strings.Join(pageIDs, ",") == strings.Join(previous, ",") {
+			return nil
+		}
+		previous = pageIDs
+
+		if len(page) < accountPageSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Call API to set the state of an account to enabled or disabled
+func setState(ctx context.Context, id, state string, m *Client) error {
+	request, err := m.newRequest(ctx, http.MethodPut, "account/"+id+"/state/"+state, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := m.do(request)
+	if err != nil {
+		return err
+	}
+	discardBody(response)
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("error setting account %v to %v", id, state)
+	}
+	return nil
+}
+
+// Call API to get accounts matching the given search term. Returns the decoded list of accounts.
+func getAccount(ctx context.Context, term string, m *Client) ([]interface{}, error) {
+	return searchAccounts(ctx, url.Values{"Term": []string{term}}, m)
+}
+
+// Call API to search for accounts using the given query parameters. Returns the decoded list of accounts.
+func searchAccounts(ctx context.Context, query url.Values, m *Client) ([]interface{}, error) {
+	request, err := m.newRequest(ctx, http.MethodGet, "accounts?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := m.do(request)
 	if err != nil {
-		log.Printf("! Error making request")
 		return nil, err
 	}
 
 	status := response.StatusCode
 	if status != http.StatusOK {
+		discardBody(response)
 		return nil, fmt.Errorf("Error retrieving account. Status code was %d", status)
 	}
 
-	log.Printf("! returning from getAccount with response = %+v", response)
-	return response, nil
+	body := make([]interface{}, 0)
+	err = decodeBody(response, &body)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Call API to search for accounts with the given username. The search also returns accounts that only partly
+// match, so only those whose username matches exactly, ignoring case, are kept.
+func getAccountsByUsername(ctx context.Context, username string, m *Client) ([]map[string]interface{}, error) {
+	body, err := getAccount(ctx, username, m)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]map[string]interface{}, 0)
+	for _, item := range body {
+		asMap := item.(map[string]interface{})
+		for _, user := range accountFromMap(asMap).Usernames {
+			if strings.EqualFold(user, username) {
+				matches = append(matches, asMap)
+				break
+			}
+		}
+	}
+	return matches, nil
+}
+
+// Call API to get the account with the given ID. Returns nil if there is no such account.
+func getAccountByID(ctx context.Context, id string, m *Client) (map[string]interface{}, error) {
+	request, err := m.newRequest(ctx, http.MethodGet, "account/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := m.do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	status := response.StatusCode
+	// Only a 404 means the account is gone. Anything else, such as a 400 for a malformed ID, is an error rather
+	// than a reason to drop the account from state.
+	if status == http.StatusNotFound {
+		discardBody(response)
+		return nil, nil
+	}
+	if status != http.StatusOK {
+		discardBody(response)
+		return nil, fmt.Errorf("Error retrieving account %v. Status code was %d", id, status)
+	}
+
+	body := make(map[string]interface{})
+	err = decodeBody(response, &body)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Build an account struct from an account returned by the API. An account has a username property for each of
+// its usernames, and an account created with an email address as its username also has an email property with
+// the same value. The email has always been what the provider reads as the username, so it comes first when it is
+// one of the usernames. An email that was changed to something else is not a username and is left out, unless the
+// account has no username property at all. Usernames is left empty if the account has neither.
+func accountFromMap(asMap map[string]interface{}) *structs.Account {
+	email := propertyValue(asMap, "email")
+	usernames := make([]string, 0)
+	if email != "" {
+		usernames = append(usernames, email)
+	}
+	isUsername := false
+	for _, user := range propertyValues(asMap, "username") {
+		if strings.EqualFold(user, email) {
+			isUsername = true
+			continue
+		}
+		usernames = append(usernames, user)
+	}
+	if email != "" && !isUsername && len(usernames) > 1 {
+		usernames = usernames[1:]
+	}
+
+	return &structs.Account{
+		Usernames: usernames,
+		Role:      asMap["role"].(string),
+		Status:    asMap["status"].(string),
+		ID:        strconv.FormatFloat(asMap["id"].(float64), 'f', -1, 64),
+		GlobalID:  asMap["globalId"].(string),
+		Name:      propertyValue(asMap, "name"),
+		Email:     propertyValue(asMap, "email"),
+	}
+}
+
+// Whether an account returned by the API has the given ID, global ID, or username
+func accountMatches(asMap map[string]interface{}, term string) bool {
+	if strconv.FormatFloat(asMap["id"].(float64), 'f', -1, 64) == term {
+		return true
+	}
+	if strings.EqualFold(asMap["globalId"].(string), term) {
+		return true
+	}
+
+	props, _ := asMap["properties"].([]interface{})
+	for _, prop := range props {
+		propMap := prop.(map[string]interface{})
+		key, _ := propMap["key"].(string)
+		value, _ := propMap["value"].(string)
+		if (key == "username" || key == "email") && strings.EqualFold(value, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the value of the property with the given key from an account returned by the API. Returns an empty string
+// if the account does not have the property.
+func propertyValue(asMap map[string]interface{}, key string) string {
+	values := propertyValues(asMap, key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Get the values of every property with the given key from an account returned by the API. Empty values are
+// skipped.
+func propertyValues(asMap map[string]interface{}, key string) []string {
+	ret := []string{}
+	props, _ := asMap["properties"].([]interface{})
+	for _, prop := range props {
+		propMap := prop.(map[string]interface{})
+		if propKey, _ := propMap["key"].(string); strings.EqualFold(propKey, key) {
+			if value, _ := propMap["value"].(string); value != "" {
+				ret = append(ret, value)
+			}
+		}
+	}
+	return ret
+}
+
+// Get the properties of an account returned by the API that can be managed through terraform. Built-in and
+// ignored properties are left out, as are properties that were removed.
+func managedProperties(asMap map[string]interface{}, m *Client) []structs.Property {
+	props, _ := asMap["properties"].([]interface{})
+
+	ret := make([]structs.Property, 0)
+	for _, prop := range props {
+		propMap := prop.(map[string]interface{})
+		key, _ := propMap["key"].(string)
+		if m.ignoresProperty(key) {
+			continue
+		}
+		// Removed properties are left behind with an empty value
+		value, _ := propMap["value"].(string)
+		if value == "" {
+			continue
+		}
+		accountID, _ := propMap["accountId"].(float64)
+		ret = append(ret, structs.Property{
+			AccountID: int(accountID),
+			Key:       key,
+			Value:     value,
+		})
+	}
+	return ret
+}
+
+// Whether a property is left out of the state, either because Identity sets it by itself or because the
+// provider was configured to ignore it
+func (c *Client) ignoresProperty(key string) bool {
+	if IsBuiltinProperty(key) {
+		return true
+	}
+	for _, ignored := range c.IgnoredPropertyKeys {
+		if strings.EqualFold(key, ignored) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,219 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"identity_provider/internal/structs"
+	"identity_provider/internal/util"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// A fake Identity server that hands out tokens, serves one account by ID, and records the properties it is sent.
+// Tests can add their own handlers to mux.
+type fakeIdentity struct {
+	server *httptest.Server
+	mux    *http.ServeMux
+
+	mu    sync.Mutex
+	props []structs.Property
+}
+
+func newFakeIdentity(t *testing.T, account map[string]interface{}) *fakeIdentity {
+	t.Helper()
+	fake := &fakeIdentity{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connect/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+	})
+	mux.HandleFunc("/api/account/property", func(w http.ResponseWriter, r *http.Request) {
+		prop := structs.Property{}
+		if err := json.NewDecoder(r.Body).Decode(&prop); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fake.mu.Lock()
+		fake.props = append(fake.props, prop)
+		fake.mu.Unlock()
+	})
+	mux.HandleFunc("/api/account/42", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(account)
+	})
+
+	fake.mux = mux
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (fake *fakeIdentity) client() *Client {
+	httpClient := fake.server.Client()
+	tokens := util.NewTokenSource(util.TokenConfig{TokenURL: fake.server.URL, GrantType: util.GrantClientCredentials}, httpClient)
+	return NewClient(fake.server.URL+"/api", tokens, httpClient)
+}
+
+func TestReadPropertiesSkipsEmptyValues(t *testing.T) {
+	fake := newFakeIdentity(t, map[string]interface{}{
+		"id":       42,
+		"globalId": "8c1e0d36-0f6b-4a4e-9a4a-5f1d4d1b2c3a",
+		"properties": []interface{}{
+			map[string]interface{}{"accountId": 42, "key": "username", "value": "blue-1@range.example"},
+			map[string]interface{}{"accountId": 42, "key": "team", "value": "blue"},
+			map[string]interface{}{"accountId": 42, "key": "squad", "value": ""},
+			map[string]interface{}{"accountId": 42, "key": "badge", "value": "1234"},
+		},
+	})
+	client := fake.client()
+	client.IgnoredPropertyKeys = []string{"Badge"}
+
+	props, err := ReadProperties(context.Background(), "42", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*props) != 1 {
+		t.Fatalf("got properties %v, want only team", *props)
+	}
+	if prop := (*props)[0]; prop["key"] != "team" || prop["value"] != "blue" || prop["account_id"] != 42 {
+		t.Errorf("got property %v, want team = blue on account 42", prop)
+	}
+}
+
+func TestRemovePropertiesBlanksValues(t *testing.T) {
+	fake := newFakeIdentity(t, nil)
+
+	err := RemoveProperties(context.Background(), &[]*structs.Property{
+		{AccountID: 42, Key: "team", Value: "blue"},
+	}, fake.client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := structs.Property{AccountID: 42, Key: "team", Value: ""}
+	if len(fake.props) != 1 || fake.props[0] != want {
+		t.Errorf("got properties %v, want %v", fake.props, want)
+	}
+}
+
+func TestAccountFromMapUsernames(t *testing.T) {
+	account := func(props ...string) map[string]interface{} {
+		list := make([]interface{}, 0)
+		for i := 0; i < len(props); i += 2 {
+			list = append(list, map[string]interface{}{"key": props[i], "value": props[i+1]})
+		}
+		return map[string]interface{}{"id": float64(42), "globalId": "", "role": "Member", "status": StatusEnabled, "properties": list}
+	}
+
+	tests := []struct {
+		name    string
+		account map[string]interface{}
+		want    []string
+	}{
+		{"email comes first", account("username", "b@range.example", "username", "A@range.example", "email", "a@range.example"),
+			[]string{"a@range.example", "b@range.example"}},
+		{"changed email is not a username", account("username", "a@range.example", "email", "someone@else.example"),
+			[]string{"a@range.example"}},
+		{"email without usernames", account("email", "a@range.example"), []string{"a@range.example"}},
+		{"usernames without email", account("username", "a"), []string{"a"}},
+		{"neither", account("name", "A"), []string{}},
+	}
+	for _, test := range tests {
+		got := accountFromMap(test.account).Usernames
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got usernames %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got usernames %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+// Serve a search of count accounts, with IDs counting up from 1000, a page at a time. shift moves every page after
+// the first back by that many accounts, like an account being created during the search does. Returns a counter of
+// the pages requested.
+func serveSearch(fake *fakeIdentity, count, shift int) *int32 {
+	var pages int32
+	fake.mux.HandleFunc("/api/accounts", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pages, 1)
+		skip, _ := strconv.Atoi(r.URL.Query().Get("Skip"))
+		take, _ := strconv.Atoi(r.URL.Query().Get("Take"))
+		if skip > 0 {
+			skip -= shift
+		}
+		page := make([]interface{}, 0)
+		for i := skip; i < skip+take && i < count; i++ {
+			page = append(page, map[string]interface{}{"id": float64(1000 + i), "globalId": "", "role": "Member", "status": StatusEnabled})
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+	return &pages
+}
+
+func TestSearchAccountsSkipsRepeatedAccounts(t *testing.T) {
+	fake := newFakeIdentity(t, nil)
+	serveSearch(fake, 250, 1)
+
+	accounts, err := SearchAccounts(context.Background(), "", fake.client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 250 {
+		t.Errorf("got %d accounts, want all 250", len(accounts))
+	}
+}
+
+func TestSearchAccountsStopsWhenSkipIsIgnored(t *testing.T) {
+	fake := newFakeIdentity(t, nil)
+	// Every page is the first one again
+	serveSearch(fake, 250, accountPageSize)
+
+	accounts, err := SearchAccounts(context.Background(), "", fake.client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != accountPageSize {
+		t.Errorf("got %d accounts, want the %d of the first page", len(accounts), accountPageSize)
+	}
+}
+
+func TestFindAccountsByIDStopsWhenAllFound(t *testing.T) {
+	fake := newFakeIdentity(t, nil)
+	pages := serveSearch(fake, 1000, 0)
+
+	found, err := FindAccountsByID(context.Background(), []string{"1000", "1150"}, 0, fake.client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found["1150"] == nil {
+		t.Errorf("got accounts %v, want 1000 and 1150", found)
+	}
+	if n := atomic.LoadInt32(pages); n != 2 {
+		t.Errorf("read %d pages, want to stop after the 2 holding the accounts", n)
+	}
+}
+
+func TestFindAccountsByIDStopsAtMaxPages(t *testing.T) {
+	fake := newFakeIdentity(t, nil)
+	pages := serveSearch(fake, 1000, 0)
+
+	found, err := FindAccountsByID(context.Background(), []string{"1000", "1900"}, 3, fake.client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found["1000"] == nil {
+		t.Errorf("got accounts %v, want only 1000", found)
+	}
+	if n := atomic.LoadInt32(pages); n != 3 {
+		t.Errorf("read %d pages, want 3", n)
+	}
+}
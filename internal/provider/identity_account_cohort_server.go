@@ -0,0 +1,359 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"identity_provider/internal/api"
+	"identity_provider/internal/util"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/id"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Placeholders that can be used in a cohort's username template
+const (
+	cohortTeamPlaceholder   = "{team}"
+	cohortNumberPlaceholder = "{n}"
+)
+
+// Shortest password a cohort can be given
+const minCohortPasswordLength = 8
+
+// Manages a group of accounts whose usernames come from a template, e.g. one account per seat on each team of an
+// exercise. Passwords are generated locally and exported for the instructors. This is built on the same engine as
+// identity_account_roster.
+func identityAccountCohort() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: identityAccountCohortCreate,
+		ReadContext:   identityAccountCohortRead,
+		UpdateContext: identityAccountCohortUpdate,
+		DeleteContext: identityAccountCohortDelete,
+
+		CustomizeDiff: identityAccountCohortDiff,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(30 * time.Minute),
+			Read:   schema.DefaultTimeout(10 * time.Minute),
+			Update: schema.DefaultTimeout(30 * time.Minute),
+			Delete: schema.DefaultTimeout(30 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			// e.g. blue-{team}-{n}@range.example
+			"username_template": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			// Substituted for {team}. Required if the template uses it.
+			"teams": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			// Substituted for {n}, counting from 1
+			"accounts_per_team": {
+				Type:     schema.TypeInt,
+				Required: true,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					if value.(int) < 1 {
+						return nil, []error{fmt.Errorf("%s must be at least 1", key)}
+					}
+					return nil, nil
+				},
+			},
+			"role": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  defaultRosterRole,
+			},
+			// Set on every account in the cohort
+			"properties": {
+				Type:     schema.TypeMap,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					for prop := range value.(map[string]interface{}) {
+						if api.IsBuiltinProperty(prop) {
+							return nil, []error{fmt.Errorf("%s cannot use the built-in keys %v", key, api.BuiltinPropertyKeys)}
+						}
+					}
+					return nil, nil
+				},
+			},
+			// Only used for accounts added to the cohort after a change. Existing accounts keep their password.
+			"password_length": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  20,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					if value.(int) < minCohortPasswordLength {
+						return nil, []error{fmt.Errorf("%s must be at least %d", key, minCohortPasswordLength)}
+					}
+					return nil, nil
+				},
+			},
+			"password_charset": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  util.DefaultPasswordCharset,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					if value.(string) == "" {
+						return nil, []error{fmt.Errorf("%s cannot be empty", key)}
+					}
+					return nil, nil
+				},
+			},
+			"batch_size": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  defaultRosterBatchSize,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					if value.(int) < 1 {
+						return nil, []error{fmt.Errorf("%s must be at least 1", key)}
+					}
+					return nil, nil
+				},
+			},
+			// What to do with accounts of the cohort that already exist. Adopting an account someone may be using is opt-in.
+			"on_conflict": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  onConflictAdoptIfDisabled,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					str := value.(string)
+					if str != onConflictAdopt && str != onConflictFail && str != onConflictAdoptIfDisabled {
+						return nil, []error{fmt.Errorf("%s must be %q, %q, or %q", key, onConflictAdopt, onConflictFail, onConflictAdoptIfDisabled)}
+					}
+					return nil, nil
+				},
+			},
+			// Adopted accounts keep their password unless this is set
+			"set_adopted_passwords": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			// Username -> generated password. Adopted accounts are left out unless set_adopted_passwords is set.
+			"passwords": {
+				Type:      schema.TypeMap,
+				Computed:  true,
+				Sensitive: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			// Username -> account ID
+			"account_ids": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			// Username -> global ID
+			"global_ids": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+		},
+	}
+}
+
+func identityAccountCohortCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	log.Printf("! At top of identityAccountCohortCreate")
+
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
+	d.SetId(id.UniqueId())
+
+	return applyCohort(ctx, d, m)
+}
+
+func identityAccountCohortRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
+	return diag.FromErr(readRoster(ctx, d, m.(*api.Client)))
+}
+
+func identityAccountCohortUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
+	return applyCohort(ctx, d, m)
+}
+
+// Like identity_account, this disables the accounts rather than deleting them
+func identityAccountCohortDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
+	return diag.FromErr(disableRoster(ctx, d, m.(*api.Client)))
+}
+
+func identityAccountCohortDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	for _, key := range []string{"username_template", "teams", "accounts_per_team", "role", "properties"} {
+		if !d.NewValueKnown(key) {
+			return nil
+		}
+	}
+
+	usernames, err := cohortUsernames(d.Get("username_template").(string), d.Get("teams").([]interface{}),
+		d.Get("accounts_per_team").(int))
+	if err != nil {
+		return err
+	}
+
+	// Only the usernames matter here, the passwords are filled in on apply
+	rows := make([]*rosterRow, 0, len(usernames))
+	for _, user := range usernames {
+		rows = append(rows, &rosterRow{Username: user})
+	}
+	return diffRoster(rows, d, "passwords")
+}
+
+// Create, update, or disable the accounts of a cohort to match its config. Accounts that are new to the cohort
+// get a generated password, the rest keep the one in state. Adopted accounts that kept their own password have
+// none in state, and are left without one.
+func applyCohort(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	oldPasswordsGeneric, _ := d.GetChange("passwords")
+	passwords := stringMap(oldPasswordsGeneric.(map[string]interface{}))
+	oldIDs, _ := d.GetChange("account_ids")
+	tracked := oldIDs.(map[string]interface{})
+
+	oldTemplate, currTemplate := d.GetChange("username_template")
+	oldTeams, currTeams := d.GetChange("teams")
+	oldCount, currCount := d.GetChange("accounts_per_team")
+	oldRole, currRole := d.GetChange("role")
+	oldProps, currProps := d.GetChange("properties")
+
+	var oldRows []*rosterRow
+	if !d.IsNewResource() {
+		usernames, err := cohortUsernames(oldTemplate.(string), oldTeams.([]interface{}), oldCount.(int))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		oldRows = cohortRows(usernames, passwords, oldRole.(string), oldProps.(map[string]interface{}))
+	}
+
+	usernames, err := cohortUsernames(currTemplate.(string), currTeams.([]interface{}), currCount.(int))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Keep passwords for accounts still in the cohort and generate them for new ones
+	currPasswords := make(map[string]string)
+	for _, user := range usernames {
+		if password, ok := passwords[user]; ok {
+			currPasswords[user] = password
+			continue
+		}
+		if _, ok := tracked[user]; ok {
+			continue
+		}
+		password, err := util.GeneratePassword(d.Get("password_length").(int), d.Get("password_charset").(string))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		currPasswords[user] = password
+	}
+	currRows := cohortRows(usernames, currPasswords, currRole.(string), currProps.(map[string]interface{}))
+
+	// Save the passwords first so they are kept even if creating some of the accounts fails
+	err = d.Set("passwords", currPasswords)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	opts := rosterOptionsFrom(d)
+	adopted, diags := applyRoster(ctx, d, oldRows, currRows, opts, m.(*api.Client))
+
+	// An adopted account that kept its own password doesn't have the generated one
+	if !opts.setAdoptedPasswords && len(adopted) > 0 {
+		for _, user := range adopted {
+			delete(currPasswords, user)
+		}
+		err = d.Set("passwords", currPasswords)
+		if err != nil {
+			diags = append(diags, diag.FromErr(err)...)
+		}
+	}
+	if diags.HasError() {
+		if !d.IsNewResource() {
+			diags = append(diags, keepOldValues(d, "username_template", "teams", "accounts_per_team", "role", "properties")...)
+		}
+		return diags
+	}
+	return append(diags, identityAccountCohortRead(ctx, d, m)...)
+}
+
+// Expand a username template for each team and seat. Every username must be unique, so a template used with
+// more than one team or seat needs the matching placeholders.
+func cohortUsernames(template string, teams []interface{}, count int) ([]string, error) {
+	teamNames := make([]string, 0, len(teams))
+	for _, team := range teams {
+		teamNames = append(teamNames, team.(string))
+	}
+
+	if strings.Contains(template, cohortTeamPlaceholder) && len(teamNames) == 0 {
+		return nil, fmt.Errorf("username_template uses %s but no teams are given", cohortTeamPlaceholder)
+	}
+	// Without teams there is a single group of accounts
+	if len(teamNames) == 0 {
+		teamNames = []string{""}
+	}
+
+	ret := make([]string, 0, len(teamNames)*count)
+	seen := make(map[string]bool)
+	for _, team := range teamNames {
+		for n := 1; n <= count; n++ {
+			user := strings.ReplaceAll(template, cohortTeamPlaceholder, team)
+			user = strings.ReplaceAll(user, cohortNumberPlaceholder, strconv.Itoa(n))
+			if seen[strings.ToLower(user)] {
+				return nil, fmt.Errorf("username_template produces %v more than once. Use %s and %s to tell accounts apart",
+					user, cohortTeamPlaceholder, cohortNumberPlaceholder)
+			}
+			seen[strings.ToLower(user)] = true
+			ret = append(ret, user)
+		}
+	}
+	return ret, nil
+}
+
+// Build the roster rows for a cohort
+func cohortRows(usernames []string, passwords map[string]string, role string, props map[string]interface{}) []*rosterRow {
+	shared := make(map[string]string)
+	for key, value := range props {
+		shared[key] = value.(string)
+	}
+
+	rows := make([]*rosterRow, 0, len(usernames))
+	for _, user := range usernames {
+		rows = append(rows, &rosterRow{
+			Username:   user,
+			Password:   passwords[user],
+			Role:       role,
+			Properties: shared,
+		})
+	}
+	return rows
+}
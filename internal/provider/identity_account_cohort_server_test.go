@@ -0,0 +1,86 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func cohortConfig(role string, setAdoptedPasswords bool) map[string]interface{} {
+	return map[string]interface{}{
+		"username_template":     "user-{n}@range.example",
+		"accounts_per_team":     3,
+		"role":                  role,
+		"on_conflict":           onConflictAdopt,
+		"set_adopted_passwords": setAdoptedPasswords,
+	}
+}
+
+func TestCohortLeavesAdoptedPasswordsOut(t *testing.T) {
+	fake := newFakeRoster(t)
+	fake.taken["user-2@range.example"] = true
+	client := fake.client()
+	resource := identityAccountCohort()
+
+	diff, err := resource.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(cohortConfig("Member", false)), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, diags := resource.Apply(context.Background(), nil, diff, client)
+	if diags.HasError() {
+		t.Fatalf("create failed: %v", diags)
+	}
+	if got := state.Attributes["account_ids.user-2@range.example"]; got != "101" {
+		t.Errorf("got account ID %q for the adopted account, want 101", got)
+	}
+	// The adopted account kept its own password, so the generated one would be wrong
+	if got, ok := state.Attributes["passwords.user-2@range.example"]; ok {
+		t.Errorf("got password %q for the adopted account, want none", got)
+	}
+	for _, user := range []string{"user-1@range.example", "user-3@range.example"} {
+		if state.Attributes["passwords."+user] == "" {
+			t.Errorf("got no password for %v", user)
+		}
+	}
+
+	// A later change to the cohort doesn't give the adopted account a password either
+	diff, err = resource.Diff(context.Background(), state, terraform.NewResourceConfigRaw(cohortConfig("Admin", false)), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, diags = resource.Apply(context.Background(), state, diff, client)
+	if diags.HasError() {
+		t.Fatalf("update failed: %v", diags)
+	}
+	if got, ok := state.Attributes["passwords.user-2@range.example"]; ok {
+		t.Errorf("got password %q for the adopted account after an update, want none", got)
+	}
+	if len(fake.passwordSets) != 0 {
+		t.Errorf("set the password of accounts %v, want none", fake.passwordSets)
+	}
+}
+
+func TestCohortSetAdoptedPasswords(t *testing.T) {
+	fake := newFakeRoster(t)
+	fake.taken["user-2@range.example"] = true
+	client := fake.client()
+	resource := identityAccountCohort()
+
+	diff, err := resource.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(cohortConfig("Member", true)), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, diags := resource.Apply(context.Background(), nil, diff, client)
+	if diags.HasError() {
+		t.Fatalf("create failed: %v", diags)
+	}
+	if state.Attributes["passwords.user-2@range.example"] == "" {
+		t.Error("got no password for the adopted account, want the generated one")
+	}
+	if len(fake.passwordSets) != 1 || fake.passwordSets[0] != "101" {
+		t.Errorf("set the password of accounts %v, want only 101", fake.passwordSets)
+	}
+}
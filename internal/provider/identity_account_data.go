@@ -0,0 +1,155 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package provider
+
+import (
+	"context"
+	"identity_provider/internal/api"
+	"identity_provider/internal/structs"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Data source for looking up an existing account that is not managed by this configuration
+func identityAccountData() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: identityAccountDataRead,
+
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(2 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			// Exactly one of these three identifies the account
+			"username": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ExactlyOneOf: []string{"username", "account_id", "global_id"},
+			},
+			"account_id": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ExactlyOneOf: []string{"username", "account_id", "global_id"},
+			},
+			"global_id": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ExactlyOneOf: []string{"username", "account_id", "global_id"},
+			},
+			"role": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"status": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"email": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			// Same as the properties of the resource. Built-in and ignored properties are left out.
+			"property": {
+				Type:     schema.TypeSet,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"account_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"key": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"value": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func identityAccountDataRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	log.Printf("! At top of identityAccountDataRead")
+
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+	casted := m.(*api.Client)
+
+	var acct *structs.Account
+	var err error
+	if id := d.Get("account_id").(string); id != "" {
+		acct, err = api.ReadAccountByID(ctx, id, casted)
+		if err == nil && acct == nil {
+			return diag.Errorf("No account found with ID %v", id)
+		}
+	} else if user := d.Get("username").(string); user != "" {
+		acct, err = api.ReadAccount(ctx, user, casted)
+	} else {
+		acct, err = api.FindAccount(ctx, d.Get("global_id").(string), casted)
+	}
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.SetId(acct.ID)
+
+	err = d.Set("account_id", acct.ID)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = d.Set("global_id", acct.GlobalID)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if len(acct.Usernames) > 0 {
+		err = d.Set("username", acct.Usernames[0])
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	err = d.Set("role", acct.Role)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = d.Set("status", acct.Status)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = d.Set("name", acct.Name)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = d.Set("email", acct.Email)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	props, err := api.ReadProperties(ctx, acct.ID, casted)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	return diag.FromErr(d.Set("property", props))
+}
@@ -0,0 +1,210 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"identity_provider/internal/api"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/id"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Manages one account per row of a roster document. Accounts are created in batches, and each row is tracked on
+// its own so that changing the roster only touches the rows that changed.
+func identityAccountRoster() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: identityAccountRosterCreate,
+		ReadContext:   identityAccountRosterRead,
+		UpdateContext: identityAccountRosterUpdate,
+		DeleteContext: identityAccountRosterDelete,
+
+		CustomizeDiff: identityAccountRosterDiff,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(30 * time.Minute),
+			Read:   schema.DefaultTimeout(10 * time.Minute),
+			Update: schema.DefaultTimeout(30 * time.Minute),
+			Delete: schema.DefaultTimeout(30 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			// Sensitive because rows can hold passwords
+			"roster": {
+				Type:      schema.TypeString,
+				Required:  true,
+				Sensitive: true,
+			},
+			"format": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  rosterFormatCSV,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					str := value.(string)
+					if str != rosterFormatCSV && str != rosterFormatJSON {
+						return nil, []error{fmt.Errorf("%s must be %q or %q", key, rosterFormatCSV, rosterFormatJSON)}
+					}
+					return nil, nil
+				},
+			},
+			// Used for rows that don't give their own password
+			"default_password": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+			// Used for rows that don't give their own role
+			"default_role": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  defaultRosterRole,
+			},
+			"batch_size": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  defaultRosterBatchSize,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					if value.(int) < 1 {
+						return nil, []error{fmt.Errorf("%s must be at least 1", key)}
+					}
+					return nil, nil
+				},
+			},
+			// What to do with rows whose account already exists. Adopting an account someone may be using is opt-in.
+			"on_conflict": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  onConflictAdoptIfDisabled,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					str := value.(string)
+					if str != onConflictAdopt && str != onConflictFail && str != onConflictAdoptIfDisabled {
+						return nil, []error{fmt.Errorf("%s must be %q, %q, or %q", key, onConflictAdopt, onConflictFail, onConflictAdoptIfDisabled)}
+					}
+					return nil, nil
+				},
+			},
+			// Adopted accounts keep their password unless this is set
+			"set_adopted_passwords": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			// Username -> account ID for every row that has an account
+			"account_ids": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			// Username -> global ID for every row that has an account
+			"global_ids": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+		},
+	}
+}
+
+func identityAccountRosterCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	log.Printf("! At top of identityAccountRosterCreate")
+
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
+	_, rows, err := rosterVersions(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// The roster itself doesn't exist in Identity, so it just gets a random ID
+	d.SetId(id.UniqueId())
+
+	_, diags := applyRoster(ctx, d, nil, rows, rosterOptionsFrom(d), m.(*api.Client))
+	if diags.HasError() {
+		return diags
+	}
+	return append(diags, identityAccountRosterRead(ctx, d, m)...)
+}
+
+func identityAccountRosterRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
+	return diag.FromErr(readRoster(ctx, d, m.(*api.Client)))
+}
+
+func identityAccountRosterUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
+	oldRows, currRows, err := rosterVersions(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	_, diags := applyRoster(ctx, d, oldRows, currRows, rosterOptionsFrom(d), m.(*api.Client))
+	if diags.HasError() {
+		return append(diags, keepOldValues(d, "roster", "format", "default_password", "default_role")...)
+	}
+	return append(diags, identityAccountRosterRead(ctx, d, m)...)
+}
+
+// Like identity_account, this disables the accounts rather than deleting them
+func identityAccountRosterDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
+	return diag.FromErr(disableRoster(ctx, d, m.(*api.Client)))
+}
+
+func identityAccountRosterDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	// The roster can't be parsed yet if it depends on something that isn't known until apply
+	for _, key := range []string{"roster", "format", "default_password", "default_role"} {
+		if !d.NewValueKnown(key) {
+			return nil
+		}
+	}
+
+	rows, err := parseRoster(d.Get("roster").(string), d.Get("format").(string),
+		d.Get("default_password").(string), d.Get("default_role").(string))
+	if err != nil {
+		return err
+	}
+	return diffRoster(rows, d)
+}
+
+// Parse the roster as of the last apply and the one being applied, each with the format and defaults it was
+// given with. Changing a default changes every row that relies on it. The old roster is empty on create.
+func rosterVersions(d *schema.ResourceData) ([]*rosterRow, []*rosterRow, error) {
+	oldRoster, currRoster := d.GetChange("roster")
+	oldFormat, currFormat := d.GetChange("format")
+	oldPassword, currPassword := d.GetChange("default_password")
+	oldRole, currRole := d.GetChange("default_role")
+
+	var oldRows []*rosterRow
+	if !d.IsNewResource() && oldRoster.(string) != "" {
+		var err error
+		oldRows, err = parseRoster(oldRoster.(string), oldFormat.(string), oldPassword.(string), oldRole.(string))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	currRows, err := parseRoster(currRoster.(string), currFormat.(string), currPassword.(string), currRole.(string))
+	if err != nil {
+		return nil, nil, err
+	}
+	return oldRows, currRows, nil
+}
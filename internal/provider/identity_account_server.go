@@ -3,69 +3,171 @@
 package provider
 
 import (
+	"context"
 	"fmt"
 	"identity_provider/internal/api"
 	"identity_provider/internal/structs"
 	"log"
-	"sort"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
+// What happens to an account when it is destroyed
+const (
+	// Disable the account. This is what Identity does in place of deleting accounts.
+	deletionPolicyDisable = "disable"
+	// Leave the account as it is and only remove it from state
+	deletionPolicyAbandon = "abandon"
+	// Disable the account, remove its managed properties, and reset its role
+	deletionPolicyScrub = "scrub"
+)
+
+// What happens when the account to create already exists in Identity
+const (
+	// Take over the existing account
+	onConflictAdopt = "adopt"
+	// Fail the create
+	onConflictFail = "fail"
+	// Take over the existing account only if it is disabled, i.e. it is not in use
+	onConflictAdoptIfDisabled = "adopt_if_disabled"
+)
+
+// Role given to accounts that are scrubbed on destroy
+const scrubbedRole = "Member"
+
 func identityAccount() *schema.Resource {
 	return &schema.Resource{
-		Create: identityAccountCreate,
-		Read:   identityAccountRead,
-		Update: identityAccountUpdate,
-		Delete: identityAccountDelete,
+		CreateContext: identityAccountCreate,
+		ReadContext:   identityAccountRead,
+		UpdateContext: identityAccountUpdate,
+		DeleteContext: identityAccountDelete,
+
+		Importer: &schema.ResourceImporter{
+			StateContext: identityAccountImport,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(2 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(2 * time.Minute),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"username": {
 				Type:     schema.TypeString,
 				Required: true,
 			},
+			// Other usernames the account can log in with
+			"aliases": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
 			"password": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:      schema.TypeString,
+				Required:  true,
+				Sensitive: true,
 			},
 			"role": {
 				Type:     schema.TypeString,
 				Optional: true,
 			},
+			// Display name shown by Player and the other apps. Stored in the built-in name property.
+			"name": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			// Stored in the built-in email property. Identity sets it to the username if it isn't given.
+			"email": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
 			"global_id": {
 				Type:     schema.TypeString,
 				Computed: true,
 			},
-			// Compute b/c it doesn't make sense for an account to be initialzed as inactive.
-			// Will change if someone wants to do that.
+			// Accounts can be created disabled and enabled later, e.g. when an exercise starts
 			"status": {
 				Type:     schema.TypeString,
-				Computed: true,
+				Optional: true,
+				Default:  api.StatusEnabled,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					str := value.(string)
+					if str != api.StatusEnabled && str != api.StatusDisabled {
+						return nil, []error{fmt.Errorf("%s must be %q or %q", key, api.StatusEnabled, api.StatusDisabled)}
+					}
+					return nil, nil
+				},
 			},
-			// Will run into similar issues as the admin team with properties generated implicitly.
-			// For now just skip the first three properties
+			// Only used on create, so changing it does not call the API
+			"on_conflict": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  onConflictAdopt,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					str := value.(string)
+					if str != onConflictAdopt && str != onConflictFail && str != onConflictAdoptIfDisabled {
+						return nil, []error{fmt.Errorf("%s must be %q, %q, or %q", key, onConflictAdopt, onConflictFail, onConflictAdoptIfDisabled)}
+					}
+					return nil, nil
+				},
+			},
+			// Only used on destroy, so changing it does not call the API
+			"deletion_policy": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  deletionPolicyDisable,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					str := value.(string)
+					if str != deletionPolicyDisable && str != deletionPolicyAbandon && str != deletionPolicyScrub {
+						return nil, []error{fmt.Errorf("%s must be %q, %q, or %q", key, deletionPolicyDisable, deletionPolicyAbandon, deletionPolicyScrub)}
+					}
+					return nil, nil
+				},
+			},
+			// Identity sets the name, username, and email properties by itself. These are skipped when reading,
+			// along with any keys the provider is configured to ignore.
+			// Properties are a set so that they are matched up by key rather than by position.
 			"property": {
-				Type:     schema.TypeList,
+				Type:     schema.TypeSet,
 				Optional: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
-						// Storing ID is not necessary. We can change a value given a key,
-						// and changing a key creates a new property
+						// Storing ID is not necessary. We can change a value given a key
 						"account_id": {
 							Type:     schema.TypeInt,
 							Computed: true,
 						},
-						// Keys cannot change. This "destroys" and recreates the whole account, but since destroying
-						// is just deactivating, it achieves the same result as simply appending another property
+						// Changing a key removes the property with the old key and adds one with the new key
 						"key": {
 							Type:     schema.TypeString,
 							Required: true,
-							ForceNew: true,
+							ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+								if api.IsBuiltinProperty(value.(string)) {
+									return nil, []error{fmt.Errorf("%s cannot be one of the built-in keys %v", key, api.BuiltinPropertyKeys)}
+								}
+								return nil, nil
+							},
 						},
+						// An empty value is how a removed property is stored, so it can't be used as a real value
 						"value": {
 							Type:     schema.TypeString,
 							Required: true,
+							ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+								if value.(string) == "" {
+									return nil, []error{fmt.Errorf("%s cannot be empty", key)}
+								}
+								return nil, nil
+							},
 						},
 					},
 				},
@@ -74,12 +176,17 @@ func identityAccount() *schema.Resource {
 	}
 }
 
-func identityAccountCreate(d *schema.ResourceData, m interface{}) error {
+func identityAccountCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	log.Printf("! At top of identityAccountCreate")
-	log.Printf("! properties: %+v", d.Get("property"))
 
 	if m == nil {
-		return fmt.Errorf("Error configuring provider")
+		return diag.Errorf("Error configuring provider")
+	}
+
+	// Catch duplicate property keys before anything is created
+	_, err := propertiesByKey(d.Get("property").(*schema.Set))
+	if err != nil {
+		return diag.FromErr(err)
 	}
 
 	// API wants username to be an array, but that doesn't make sense in tf, so just make it an array of size 1
@@ -87,32 +194,41 @@ func identityAccountCreate(d *schema.ResourceData, m interface{}) error {
 		Usernames: []string{d.Get("username").(string)},
 		Password:  d.Get("password").(string),
 		Role:      d.Get("role").(string),
-		Status:    d.Get("status").(string),
 	}
+	status := d.Get("status").(string)
 
-	casted := m.(map[string]string)
-	exists, err := api.CreateAccount(acct, casted)
+	casted := m.(*api.Client)
+	exists, err := api.CreateAccount(ctx, acct, casted)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	email := acct.Usernames[0]
 
-	id, glob, err := api.GetIDs(email, casted)
+	var diags diag.Diagnostics
+	if exists {
+		diags, err = checkConflict(ctx, email, d.Get("on_conflict").(string), casted)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	id, glob, err := api.GetIDs(ctx, email, casted)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	if exists {
-		err = api.EnableAccount(id, casted)
+	// New accounts start out enabled. Existing accounts keep whatever status they had, so set it either way.
+	if exists || status != api.StatusEnabled {
+		err = api.SetStatus(ctx, id, status, casted)
 		if err != nil {
-			return err
+			return diag.FromErr(err)
 		}
 	}
 
 	// Set role if it is set in config
-	err = api.SetRole(id, acct.Role, casted)
+	err = api.SetRole(ctx, id, acct.Role, casted)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	d.SetId(id)
@@ -120,69 +236,75 @@ func identityAccountCreate(d *schema.ResourceData, m interface{}) error {
 	err = d.Set("global_id", glob)
 	if err != nil {
 		log.Printf("! Error setting global id in create")
-		return err
+		return diag.FromErr(err)
 	}
 
 	err = d.Set("username", acct.Usernames[0])
 	if err != nil {
 		log.Printf("! Error setting username in create")
-		return err
+		return diag.FromErr(err)
 	}
 
 	err = d.Set("password", acct.Password)
 	if err != nil {
 		log.Printf("! Error setting password in create")
-		return err
+		return diag.FromErr(err)
 	}
 
 	err = d.Set("role", acct.Role)
 	if err != nil {
 		log.Printf("! Error setting role in create")
-		return err
+		return diag.FromErr(err)
 	}
 
-	err = d.Set("status", "Enabled")
+	err = d.Set("status", status)
 	if err != nil {
 		log.Printf("! Error setting status in create")
-		return err
+		return diag.FromErr(err)
 	}
 
-	props := d.Get("property").([]interface{})
+	props := d.Get("property").(*schema.Set).List()
 	if len(props) > 0 {
-		err = createProperties(&props, d, casted)
+		err = createProperties(ctx, &props, d, casted)
 		if err != nil {
-			return err
+			return diag.FromErr(err)
 		}
 	}
 
-	return identityAccountRead(d, m)
+	err = updateBuiltinProperties(ctx, d, casted)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	for _, alias := range d.Get("aliases").(*schema.Set).List() {
+		err = api.AddUsername(ctx, id, alias.(string), casted)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	return append(diags, identityAccountRead(ctx, d, m)...)
 }
 
-func identityAccountRead(d *schema.ResourceData, m interface{}) error {
+func identityAccountRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	log.Printf("! At top of identityAccountRead")
 
 	if m == nil {
-		return fmt.Errorf("Error configuring provider")
+		return diag.Errorf("Error configuring provider")
 	}
 
-	// Ensure account is active
-	casted := m.(map[string]string)
-	user := d.Get("username").(string)
-	exists, err := api.IsActive(user, casted)
+	// Ensure account exists. Disabled accounts are still read so that a change of status shows up as drift.
+	// The account is looked up by ID so that a renamed or similarly named account is never read instead.
+	casted := m.(*api.Client)
+	acct, err := api.ReadAccountByID(ctx, d.Id(), casted)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	if !exists {
+	if acct == nil {
 		d.SetId("")
 		return nil
 	}
 
-	// Read account data (ignoring properties for now)
-	acct, err := api.ReadAccount(user, casted)
-	if err != nil {
-		return err
-	}
-
 	d.SetId(acct.ID)
 
 	err = d.Set("global_id", acct.GlobalID)
@@ -190,134 +312,240 @@ func identityAccountRead(d *schema.ResourceData, m interface{}) error {
 		log.Printf("! Error setting global id in read")
 	}
 
-	err = d.Set("username", acct.Usernames[0])
-	if err != nil {
-		log.Printf("! Error setting username in read")
-		return err
+	// Keep the username from state if the account has no username property to read it from
+	if len(acct.Usernames) > 0 {
+		user, aliases := splitUsernames(acct.Usernames, d.Get("username").(string))
+		err = d.Set("username", user)
+		if err != nil {
+			log.Printf("! Error setting username in read")
+			return diag.FromErr(err)
+		}
+
+		err = d.Set("aliases", aliases)
+		if err != nil {
+			log.Printf("! Error setting aliases in read")
+			return diag.FromErr(err)
+		}
 	}
 
 	err = d.Set("role", acct.Role)
 	if err != nil {
 		log.Printf("! Error setting role in read")
-		return err
+		return diag.FromErr(err)
+	}
+
+	err = d.Set("name", acct.Name)
+	if err != nil {
+		log.Printf("! Error setting name in read")
+		return diag.FromErr(err)
+	}
+
+	err = d.Set("email", acct.Email)
+	if err != nil {
+		log.Printf("! Error setting email in read")
+		return diag.FromErr(err)
 	}
 
 	err = d.Set("status", acct.Status)
 	if err != nil {
 		log.Printf("! Error setting status in read")
-		return err
+		return diag.FromErr(err)
 	}
 
-	// Password cannot be read from remote state, so ignore.
-	// Maybe be possible to update pw in tf, but it is immutable for now
+	// Password cannot be read from remote state, so ignore. Changes to it are pushed in update.
 
 	// Read state of properties
-	props, err := api.ReadProperties(d.Id(), casted)
+	props, err := api.ReadProperties(ctx, d.Id(), casted)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	return d.Set("property", props)
+	return diag.FromErr(d.Set("property", props))
 }
 
-func identityAccountUpdate(d *schema.ResourceData, m interface{}) error {
+func identityAccountUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	if m == nil {
-		return fmt.Errorf("Error configuring provider")
+		return diag.Errorf("Error configuring provider")
+	}
+	casted := m.(*api.Client)
+
+	// The only things that can be updated are the username, password, aliases, roles, status, the name and
+	// email, and the value field of properties.
+	// An imported account has no password in state, so its first update sets the configured password too. Otherwise
+	// state would claim a password the account doesn't have.
+	if d.HasChange("password") {
+		err := api.SetPassword(ctx, d.Id(), d.Get("password").(string), casted)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if d.HasChange("username") || d.HasChange("aliases") {
+		err := updateUsernames(ctx, d, casted)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if d.HasChange("status") {
+		err := api.SetStatus(ctx, d.Id(), d.Get("status").(string), casted)
+		if err != nil {
+			return diag.FromErr(err)
+		}
 	}
-	casted := m.(map[string]string)
 
-	// The only things that can be updated are roles and the value field of properties.
 	if d.HasChange("role") {
 		role := d.Get("role").(string)
-		err := api.SetRole(d.Id(), role, casted)
+		err := api.SetRole(ctx, d.Id(), role, casted)
 		if err != nil {
-			return err
+			return diag.FromErr(err)
 		}
 	}
 
+	err := updateBuiltinProperties(ctx, d, casted)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	if d.HasChange("property") {
 		oldGeneric, currGeneric := d.GetChange("property")
-		oldList := oldGeneric.([]interface{})
-		currList := currGeneric.([]interface{})
-		// Properties cannot be deleted, so having less properties than before is an error state
-		if len(currList) < len(oldList) {
-			return fmt.Errorf("properties cannot be deleted")
+		oldProps, err := propertiesByKey(oldGeneric.(*schema.Set))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		currProps, err := propertiesByKey(currGeneric.(*schema.Set))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		acctID, err := strconv.Atoi(d.Id())
+		if err != nil {
+			return diag.FromErr(err)
 		}
 
-		toUpdate := new([]*structs.Property)
+		// Properties whose key is no longer in the config are removed from the account
+		toRemove := new([]*structs.Property)
+		for key, prop := range oldProps {
+			if _, ok := currProps[key]; !ok {
+				prop.AccountID = acctID
+				*toRemove = append(*toRemove, prop)
+			}
+		}
+		err = api.RemoveProperties(ctx, toRemove, casted)
+		if err != nil {
+			return diag.FromErr(err)
+		}
 
-		// Check for changes in each value field
-		for i, prop := range oldList {
-			asMapOld := prop.(map[string]interface{})
-			asMapCurr := (currList[i]).(map[string]interface{})
-			if asMapOld["value"] != asMapCurr["value"] {
-				asStruct := structs.PropertyFromMap(asMapCurr)
-				acctID, err := strconv.Atoi(d.Id())
-				if err != nil {
-					return err
-				}
-				asStruct.AccountID = acctID
-				*toUpdate = append(*toUpdate, asStruct)
+		// Properties that are new or whose value changed
+		toUpdate := new([]*structs.Property)
+		for key, prop := range currProps {
+			if old, ok := oldProps[key]; !ok || old.Value != prop.Value {
+				prop.AccountID = acctID
+				*toUpdate = append(*toUpdate, prop)
 			}
 		}
-		// We call the same endpoint as for creation. Since keys are unchanged, the API will update existing properties
-		err := api.AddProperties(toUpdate, casted)
+		// We call the same endpoint as for creation. The API will update properties that already exist
+		err = api.AddProperties(ctx, toUpdate, casted)
 		if err != nil {
-			return err
+			return diag.FromErr(err)
 		}
 	}
 
-	return identityAccountRead(d, m)
+	return identityAccountRead(ctx, d, m)
 }
 
-// Note that this does not destroy an account, it simply disables it.
+// Note that this does not destroy an account. Depending on the deletion policy, it disables it, also scrubs it,
+// or leaves it alone.
 // If someone tries to create an account with the same name, the API will not
 // error (still returns 200), but will not create the account and return a message saying the
 // account is not unique.
-func identityAccountDelete(d *schema.ResourceData, m interface{}) error {
+func identityAccountDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	if m == nil {
-		return fmt.Errorf("Error configuring provider")
+		return diag.Errorf("Error configuring provider")
+	}
+
+	policy := d.Get("deletion_policy").(string)
+	if policy == deletionPolicyAbandon {
+		log.Printf("! Abandoning account %v", d.Id())
+		return nil
 	}
 
 	id := d.Id()
-	casted := m.(map[string]string)
-	exists, err := api.IsActive(id, casted)
+	casted := m.(*api.Client)
+	if policy == deletionPolicyScrub {
+		err := scrubAccount(ctx, d, casted)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	acct, err := api.ReadAccountByID(ctx, id, casted)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	if !exists {
+	if acct == nil || acct.Status != api.StatusEnabled {
 		return nil
 	}
 
-	return api.DisableAccount(id, casted)
+	return diag.FromErr(api.DisableAccount(ctx, id, casted))
+}
+
+// Accounts can be imported by ID, global ID, or username. Only the ID and username are set here, the rest of
+// the state is filled in by the read that follows. The password can't be read back from the API.
+func identityAccountImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	if m == nil {
+		return nil, fmt.Errorf("Error configuring provider")
+	}
+
+	acct, err := api.FindAccount(ctx, d.Id(), m.(*api.Client))
+	if err != nil {
+		return nil, err
+	}
+
+	// The username is required, so we can't manage an account without one
+	if len(acct.Usernames) == 0 {
+		return nil, fmt.Errorf("Account %v has no username or email property", acct.ID)
+	}
+
+	d.SetId(acct.ID)
+	err = d.Set("username", acct.Usernames[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Not stored in Identity, so start from the defaults like a newly created account
+	err = d.Set("deletion_policy", deletionPolicyDisable)
+	if err != nil {
+		return nil, err
+	}
+	err = d.Set("on_conflict", onConflictAdopt)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*schema.ResourceData{d}, nil
 }
 
 // Create properties specified in config
-func createProperties(props *[]interface{}, d *schema.ResourceData, m map[string]string) error {
+func createProperties(ctx context.Context, props *[]interface{}, d *schema.ResourceData, m *api.Client) error {
+	accID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return err
+	}
+
 	// Get structs for the properties
 	propStructs := new([]*structs.Property)
 	for _, prop := range *props {
-		asMap := prop.(map[string]interface{})
-		curr := structs.PropertyFromMap(asMap)
-		accID, err := strconv.Atoi(d.Id())
-		if err != nil {
-			return err
-		}
+		curr := structs.PropertyFromMap(prop.(map[string]interface{}))
 		curr.AccountID = accID
 		*propStructs = append(*propStructs, curr)
 	}
 
 	// Call API
-	err := api.AddProperties(propStructs, m)
+	err = api.AddProperties(ctx, propStructs, m)
 	if err != nil {
 		return err
 	}
 
-	// Sort properties by key - same reasoning as in view
-	sort.Slice((*propStructs), func(i, j int) bool {
-		return (*propStructs)[i].Key < (*propStructs)[j].Key
-	})
-
 	// Set local state
 	localMaps := new([]map[string]interface{})
 	for _, prop := range *propStructs {
@@ -326,3 +554,149 @@ func createProperties(props *[]interface{}, d *schema.ResourceData, m map[string
 
 	return d.Set("property", localMaps)
 }
+
+// Decide whether an account that already exists can be adopted. Returns a warning if it is adopted, or an error
+// if the conflict policy doesn't allow it.
+func checkConflict(ctx context.Context, username, policy string, m *api.Client) (diag.Diagnostics, error) {
+	err := allowAdoption(ctx, username, policy, m)
+	if err != nil {
+		return nil, fmt.Errorf("%v. Import it or change on_conflict to adopt it", err)
+	}
+
+	return diag.Diagnostics{{
+		Severity: diag.Warning,
+		Summary:  "Adopted existing account",
+		Detail: fmt.Sprintf("Account %v already existed in Identity. It is now managed by Terraform and its role, "+
+			"status, and properties were set from the configuration.", username),
+	}}, nil
+}
+
+// Returns an error saying why an account that already exists can't be adopted under the given conflict policy,
+// or nil if it can
+func allowAdoption(ctx context.Context, username, policy string, m *api.Client) error {
+	switch policy {
+	case onConflictFail:
+		return fmt.Errorf("Account %v already exists", username)
+	case onConflictAdoptIfDisabled:
+		active, err := api.IsActive(ctx, username, m)
+		if err != nil {
+			return err
+		}
+		if active {
+			return fmt.Errorf("Account %v already exists and is enabled, so it may be in use", username)
+		}
+	}
+	return nil
+}
+
+// Remove the properties managed through this resource from an account and reset its role
+func scrubAccount(ctx context.Context, d *schema.ResourceData, m *api.Client) error {
+	accID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return err
+	}
+
+	props, err := propertiesByKey(d.Get("property").(*schema.Set))
+	if err != nil {
+		return err
+	}
+	toRemove := new([]*structs.Property)
+	for _, prop := range props {
+		prop.AccountID = accID
+		*toRemove = append(*toRemove, prop)
+	}
+	err = api.RemoveProperties(ctx, toRemove, m)
+	if err != nil {
+		return err
+	}
+
+	return api.SetRole(ctx, d.Id(), scrubbedRole, m)
+}
+
+// Bring the usernames of an account in line with the username and aliases in the config. The account keeps its
+// ID and global ID, so renaming it doesn't break anything that refers to it. New usernames are added before old
+// ones are removed so the account always has one to log in with.
+func updateUsernames(ctx context.Context, d *schema.ResourceData, m *api.Client) error {
+	oldUser, currUser := d.GetChange("username")
+	oldAliases, currAliases := d.GetChange("aliases")
+	oldNames := usernameSet(oldUser.(string), oldAliases.(*schema.Set))
+	currNames := usernameSet(currUser.(string), currAliases.(*schema.Set))
+
+	for key, user := range currNames {
+		if _, ok := oldNames[key]; !ok {
+			err := api.AddUsername(ctx, d.Id(), user, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for key, user := range oldNames {
+		if _, ok := currNames[key]; !ok {
+			err := api.RemoveUsername(ctx, d.Id(), user, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// All of the usernames of an account, keyed by their lowercase form
+func usernameSet(user string, aliases *schema.Set) map[string]string {
+	ret := map[string]string{strings.ToLower(user): user}
+	for _, alias := range aliases.List() {
+		ret[strings.ToLower(alias.(string))] = alias.(string)
+	}
+	return ret
+}
+
+// Split the usernames of an account into the main username and its aliases. The main username is the one in
+// state if the account still has it, otherwise the first one. Usernames are compared ignoring case.
+func splitUsernames(usernames []string, current string) (string, []string) {
+	main := usernames[0]
+	for _, user := range usernames {
+		if strings.EqualFold(user, current) {
+			main = current
+		}
+	}
+
+	aliases := make([]string, 0, len(usernames))
+	for _, user := range usernames {
+		if !strings.EqualFold(user, main) {
+			aliases = append(aliases, user)
+		}
+	}
+	return main, aliases
+}
+
+// Write the name and email attributes to the built-in properties of the account if they changed. Removing one
+// from the config leaves the property as it is.
+func updateBuiltinProperties(ctx context.Context, d *schema.ResourceData, m *api.Client) error {
+	accID, err := strconv.Atoi(d.Id())
+	if err != nil {
+		return err
+	}
+
+	toUpdate := new([]*structs.Property)
+	for _, key := range []string{"name", "email"} {
+		value := d.Get(key).(string)
+		if d.HasChange(key) && value != "" {
+			*toUpdate = append(*toUpdate, &structs.Property{AccountID: accID, Key: key, Value: value})
+		}
+	}
+
+	return api.AddProperties(ctx, toUpdate, m)
+}
+
+// Index a set of properties by their key. Each key can only be given once.
+func propertiesByKey(set *schema.Set) (map[string]*structs.Property, error) {
+	ret := make(map[string]*structs.Property)
+	for _, prop := range set.List() {
+		asStruct := structs.PropertyFromMap(prop.(map[string]interface{}))
+		if _, ok := ret[asStruct.Key]; ok {
+			return nil, fmt.Errorf("property %v is set more than once", asStruct.Key)
+		}
+		ret[asStruct.Key] = asStruct
+	}
+	return ret, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"identity_provider/internal/api"
+	"identity_provider/internal/structs"
+	"identity_provider/internal/util"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// A fake Identity server holding account 42. It records every property it is sent. Tests can add their own
+// handlers to mux.
+type fakeIdentity struct {
+	server *httptest.Server
+	mux    *http.ServeMux
+
+	mu    sync.Mutex
+	props []structs.Property
+}
+
+func newFakeIdentity(t *testing.T) *fakeIdentity {
+	t.Helper()
+	fake := &fakeIdentity{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connect/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+	})
+	mux.HandleFunc("/api/account/property", func(w http.ResponseWriter, r *http.Request) {
+		prop := structs.Property{}
+		if err := json.NewDecoder(r.Body).Decode(&prop); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fake.mu.Lock()
+		fake.props = append(fake.props, prop)
+		fake.mu.Unlock()
+	})
+	mux.HandleFunc("/api/account/42", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":       42,
+			"globalId": "8c1e0d36-0f6b-4a4e-9a4a-5f1d4d1b2c3a",
+			"role":     "Member",
+			"status":   api.StatusEnabled,
+			"properties": []interface{}{
+				map[string]interface{}{"accountId": 42, "key": "username", "value": "blue-1@range.example"},
+			},
+		})
+	})
+
+	fake.mux = mux
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (fake *fakeIdentity) client() *api.Client {
+	httpClient := fake.server.Client()
+	tokens := util.NewTokenSource(util.TokenConfig{TokenURL: fake.server.URL, GrantType: util.GrantClientCredentials}, httpClient)
+	return api.NewClient(fake.server.URL+"/api", tokens, httpClient)
+}
+
+func accountConfig(props map[string]string) map[string]interface{} {
+	blocks := make([]interface{}, 0, len(props))
+	for key, value := range props {
+		blocks = append(blocks, map[string]interface{}{"key": key, "value": value})
+	}
+	return map[string]interface{}{
+		"username": "blue-1@range.example",
+		"password": "Password",
+		"role":     "Member",
+		"property": blocks,
+	}
+}
+
+func TestAccountUpdatePropertiesByKey(t *testing.T) {
+	fake := newFakeIdentity(t)
+	client := fake.client()
+	resource := identityAccount()
+
+	old := schema.TestResourceDataRaw(t, resource.Schema, accountConfig(map[string]string{
+		"team":  "blue",
+		"squad": "red",
+		"site":  "pittsburgh",
+	}))
+	old.SetId("42")
+	state := old.State()
+
+	config := terraform.NewResourceConfigRaw(accountConfig(map[string]string{
+		"team": "green",
+		"site": "pittsburgh",
+	}))
+	diff, err := resource.Diff(context.Background(), state, config, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, diags := resource.Apply(context.Background(), state, diff, client)
+	if diags.HasError() {
+		t.Fatalf("apply failed: %v", diags)
+	}
+
+	// The removed squad block is blanked, the changed team value is sent again, and site is left alone
+	want := []structs.Property{
+		{AccountID: 42, Key: "squad", Value: ""},
+		{AccountID: 42, Key: "team", Value: "green"},
+	}
+	sort.Slice(fake.props, func(i, j int) bool { return fake.props[i].Key < fake.props[j].Key })
+	if len(fake.props) != len(want) {
+		t.Fatalf("got properties %v, want %v", fake.props, want)
+	}
+	for i := range want {
+		if fake.props[i] != want[i] {
+			t.Errorf("got property %v, want %v", fake.props[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"identity_provider/internal/api"
+	"identity_provider/internal/structs"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Data source for listing the accounts that match a search term and some optional filters
+func identityAccountsData() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: identityAccountsDataRead,
+
+		Timeouts: &schema.ResourceTimeout{
+			Read: schema.DefaultTimeout(5 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			// Passed to the Identity account search. Leave empty to consider every account.
+			"term": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			// The filters below are applied to the search results
+			"role": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"status": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					str := value.(string)
+					if str != api.StatusEnabled && str != api.StatusDisabled {
+						return nil, []error{fmt.Errorf("%s must be %q or %q", key, api.StatusEnabled, api.StatusDisabled)}
+					}
+					return nil, nil
+				},
+			},
+			"username_suffix": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"accounts": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"account_id": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"username": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"global_id": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"role": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"status": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"email": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"property": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"key": {
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+									"value": {
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func identityAccountsDataRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	log.Printf("! At top of identityAccountsDataRead")
+
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
+	term := d.Get("term").(string)
+	role := d.Get("role").(string)
+	status := d.Get("status").(string)
+	suffix := d.Get("username_suffix").(string)
+
+	accounts, err := api.SearchAccounts(ctx, term, m.(*api.Client))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	ret := make([]map[string]interface{}, 0)
+	for _, acct := range accounts {
+		if !accountPassesFilters(acct, role, status, suffix) {
+			continue
+		}
+
+		props := make([]map[string]interface{}, 0)
+		for _, prop := range acct.Properties {
+			props = append(props, map[string]interface{}{
+				"key":   prop.Key,
+				"value": prop.Value,
+			})
+		}
+
+		username := ""
+		if len(acct.Usernames) > 0 {
+			username = acct.Usernames[0]
+		}
+
+		ret = append(ret, map[string]interface{}{
+			"account_id": acct.ID,
+			"username":   username,
+			"global_id":  acct.GlobalID,
+			"role":       acct.Role,
+			"status":     acct.Status,
+			"name":       acct.Name,
+			"email":      acct.Email,
+			"property":   props,
+		})
+	}
+
+	// The results only depend on the arguments, so they make up the ID
+	d.SetId(fmt.Sprintf("%s|%s|%s|%s", term, role, status, suffix))
+
+	return diag.FromErr(d.Set("accounts", ret))
+}
+
+// Whether an account matches the role, status and username suffix filters. Empty filters match everything.
+func accountPassesFilters(acct *structs.Account, role, status, suffix string) bool {
+	if role != "" && !strings.EqualFold(acct.Role, role) {
+		return false
+	}
+	if status != "" && acct.Status != status {
+		return false
+	}
+	if suffix != "" {
+		for _, user := range acct.Usernames {
+			if strings.HasSuffix(strings.ToLower(user), strings.ToLower(suffix)) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
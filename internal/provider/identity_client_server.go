@@ -3,30 +3,46 @@
 package provider
 
 import (
+	"context"
 	"fmt"
 	"identity_provider/internal/api"
 	"identity_provider/internal/structs"
 	"log"
 	"strconv"
+	"time"
 
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func identityClient() *schema.Resource {
 	return &schema.Resource{
-		Create: identityClientCreate,
-		Read:   identityClientRead,
-		Update: identityClientUpdate,
-		Delete: identityClientDelete,
+		CreateContext: identityClientCreate,
+		ReadContext:   identityClientRead,
+		UpdateContext: identityClientUpdate,
+		DeleteContext: identityClientDelete,
+
+		Importer: &schema.ResourceImporter{
+			StateContext: identityClientImport,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(2 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(2 * time.Minute),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
 			},
+			// Computed because the name is used when this isn't set
 			"display_name": {
 				Type:     schema.TypeString,
 				Optional: true,
+				Computed: true,
 			},
 			"enabled": {
 				Type:     schema.TypeBool,
@@ -129,17 +145,21 @@ func identityClient() *schema.Resource {
 	}
 }
 
-func identityClientCreate(d *schema.ResourceData, m interface{}) error {
+func identityClientCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
 	displName := d.Get("display_name")
 	if displName == "" {
 		displName = d.Get("name")
 	}
 	client := structs.NewClient(d.Get("name").(string), displName.(string), d.Get("scopes").(string), d.Get("grants").(string), d.Get("enabled").(bool))
 
-	casted := m.(map[string]string)
-	id, err := api.CreateClient(&client, casted)
+	casted := m.(*api.Client)
+	id, err := api.CreateClient(ctx, &client, casted)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	// handle URLs
@@ -192,12 +212,12 @@ func identityClientCreate(d *schema.ResourceData, m interface{}) error {
 
 	client.Managers = []interface{}{}
 
-	err = api.UpdateClient(&client, casted)
+	err = api.UpdateClient(ctx, &client, casted)
 	// Would partial state be a better solution here?
 	if err != nil {
 		// Destroy resource if initialization fails
 		d.SetId("")
-		return err
+		return diag.FromErr(err)
 	}
 
 	client.SortFields()
@@ -206,15 +226,15 @@ func identityClientCreate(d *schema.ResourceData, m interface{}) error {
 
 	err = d.Set("name", client.Name)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("scopes", client.Scopes)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("grants", client.Grants)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	// Set state of nested resources
@@ -226,7 +246,7 @@ func identityClientCreate(d *schema.ResourceData, m interface{}) error {
 	}
 	err = d.Set("url", urlMaps)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	claimMaps := new([]map[string]interface{})
@@ -235,7 +255,7 @@ func identityClientCreate(d *schema.ResourceData, m interface{}) error {
 	}
 	err = d.Set("claim", claimMaps)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	secretMaps := new([]map[string]interface{})
@@ -244,37 +264,44 @@ func identityClientCreate(d *schema.ResourceData, m interface{}) error {
 	}
 	err = d.Set("secret", secretMaps)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	return identityClientRead(d, m)
+	return identityClientRead(ctx, d, m)
 }
 
-func identityClientRead(d *schema.ResourceData, m interface{}) error {
+func identityClientRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	if m == nil {
-		return fmt.Errorf("Error configuring provider")
+		return diag.Errorf("Error configuring provider")
 	}
 
-	client, err := api.ReadClient(d.Id(), m.(map[string]string))
+	client, err := api.ReadClient(ctx, d.Id(), m.(*api.Client))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	client.SortFields()
-	log.Printf("! Client returned by API read func: %+v", client)
 
 	// Set top level fields
 	err = d.Set("name", client.Name)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("scopes", client.Scopes)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	err = d.Set("grants", client.Grants)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+	err = d.Set("display_name", client.DisplayName)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("enabled", client.Enabled)
+	if err != nil {
+		return diag.FromErr(err)
 	}
 
 	// Set nested resource values
@@ -286,7 +313,7 @@ func identityClientRead(d *schema.ResourceData, m interface{}) error {
 	}
 	err = d.Set("url", urlMaps)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	claimMaps := new([]map[string]interface{})
@@ -295,15 +322,21 @@ func identityClientRead(d *schema.ResourceData, m interface{}) error {
 	}
 	err = d.Set("claim", claimMaps)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	// API will not show us actual value for secret, so don't bother reading/setting them
-
-	return nil
+	// API will not show us the actual value of a secret, so keep the value recorded when it was created
+	if client.Secrets == nil {
+		return nil
+	}
+	return diag.FromErr(d.Set("secret", readSecrets(d, client.Secrets)))
 }
 
-func identityClientUpdate(d *schema.ResourceData, m interface{}) error {
+func identityClientUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if m == nil {
+		return diag.Errorf("Error configuring provider")
+	}
+
 	// Fields that can be updated:
 	// top level properties
 	// value fields in urls/claims
@@ -454,18 +487,13 @@ func identityClientUpdate(d *schema.ResourceData, m interface{}) error {
 		*newSec = append(*newSec, structs.SecretFromMap(curr.(map[string]interface{})))
 	}
 
-	log.Printf("! Old secrets: %+v", oldSec)
-	log.Printf("! New secrets: %+v", newSec)
-
 	toDeleteSecret := new([]structs.Secret)
 	toCreateSecret := new([]structs.Secret)
 	// Find secrets missing from config or secrets with deleted set
 	// Also look for any secret with ID 0 - those are new ones to create
 	for _, old := range *oldSec {
 		found := false
-		log.Printf("! Old secret: %+v", old)
 		for _, curr := range *newSec {
-			log.Printf("! Current secret: %+v", curr)
 			if old.ID == curr.ID {
 				found = true
 			}
@@ -485,8 +513,7 @@ func identityClientUpdate(d *schema.ResourceData, m interface{}) error {
 		toCreateSecret = newSec
 	}
 
-	log.Printf("! Secrets to delete: %+v", toDeleteSecret)
-	log.Printf("! Secrets to create: %v", toCreateSecret)
+	log.Printf("! Deleting %d secrets and creating %d", len(*toDeleteSecret), len(*toCreateSecret))
 
 	secrets := append(*toDeleteSecret, *toCreateSecret...)
 	// If nil, set to empty array to avoid API error
@@ -498,36 +525,80 @@ func identityClientUpdate(d *schema.ResourceData, m interface{}) error {
 	} else {
 		client.Secrets = secrets
 	}
-	log.Printf("! secrets appended together: %+v", secrets)
 
 	// Ensure no URL types or claims are gone completely
 	if len(client.RedirectURLs) == 0 || len(client.CorsURLs) == 0 || len(client.PostLogoutURLs) == 0 {
-		return fmt.Errorf("there must be at least one of each URL type")
+		return diag.Errorf("there must be at least one of each URL type")
 	}
 
-	log.Printf("! Calling update with payload %+v", client)
-	err := api.UpdateClient(&client, m.(map[string]string))
+	err := api.UpdateClient(ctx, &client, m.(*api.Client))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	return identityClientRead(d, m)
+	return identityClientRead(ctx, d, m)
 }
 
-func identityClientDelete(d *schema.ResourceData, m interface{}) error {
+func identityClientDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	if m == nil {
-		return fmt.Errorf("error configuring provider")
+		return diag.Errorf("error configuring provider")
 	}
-	casted := m.(map[string]string)
+	casted := m.(*api.Client)
 
-	exists, err := api.ClientExists(d.Id(), casted)
+	exists, err := api.ClientExists(ctx, d.Id(), casted)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	if !exists {
 		log.Printf("! Client has already been deleted")
 		return nil
 	}
 
-	return api.DeleteClient(d.Id(), casted)
+	return diag.FromErr(api.DeleteClient(ctx, d.Id(), casted))
+}
+
+// Clients can be imported by ID or by name
+func identityClientImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	if m == nil {
+		return nil, fmt.Errorf("error configuring provider")
+	}
+
+	if _, err := strconv.Atoi(d.Id()); err == nil {
+		return []*schema.ResourceData{d}, nil
+	}
+
+	id, err := api.FindClient(ctx, d.Id(), m.(*api.Client))
+	if err != nil {
+		return nil, err
+	}
+	d.SetId(id)
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// Build the state of the secret blocks from the secrets the API reports. Secrets already in state keep their
+// position and value so reading doesn't reorder the list. Secrets we haven't seen before (e.g. after an import)
+// are appended without a value.
+func readSecrets(d *schema.ResourceData, secrets []structs.Secret) []map[string]interface{} {
+	remote := make(map[int]bool)
+	for _, sec := range secrets {
+		remote[sec.ID] = true
+	}
+
+	ret := make([]map[string]interface{}, 0)
+	seen := make(map[int]bool)
+	for _, item := range d.Get("secret").([]interface{}) {
+		sec := structs.SecretFromMap(item.(map[string]interface{}))
+		if remote[sec.ID] {
+			seen[sec.ID] = true
+			ret = append(ret, sec.AsMap())
+		}
+	}
+
+	for _, sec := range secrets {
+		if !seen[sec.ID] {
+			ret = append(ret, sec.AsMap())
+		}
+	}
+	return ret
 }
@@ -3,29 +3,44 @@
 package provider
 
 import (
+	"context"
+	"fmt"
+	"identity_provider/internal/api"
+	"identity_provider/internal/util"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 // Provider returns an instance of the provider
 func Provider() *schema.Provider {
 	return &schema.Provider{
 		ResourcesMap: map[string]*schema.Resource{
-			"identity_account": identityAccount(),
-			"identity_client":  identityClient(),
+			"identity_account":        identityAccount(),
+			"identity_client":         identityClient(),
+			"identity_account_roster": identityAccountRoster(),
+			"identity_account_cohort": identityAccountCohort(),
+		},
+		DataSourcesMap: map[string]*schema.Resource{
+			"identity_account":  identityAccountData(),
+			"identity_accounts": identityAccountsData(),
 		},
 		Schema: map[string]*schema.Schema{
+			// Only used with the password grant
 			"username": {
 				Type:     schema.TypeString,
-				Required: true,
+				Optional: true,
 				DefaultFunc: func() (interface{}, error) {
 					return os.Getenv("SEI_IDENTITY_USERNAME"), nil
 				},
 			},
 			"password": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
 				DefaultFunc: func() (interface{}, error) {
 					return os.Getenv("SEI_IDENTITY_PASSWORD"), nil
 				},
@@ -45,8 +60,9 @@ func Provider() *schema.Provider {
 				},
 			},
 			"client_secret": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:      schema.TypeString,
+				Required:  true,
+				Sensitive: true,
 				DefaultFunc: func() (interface{}, error) {
 					return os.Getenv("SEI_IDENTITY_CLIENT_SECRET"), nil
 				},
@@ -58,31 +74,204 @@ func Provider() *schema.Provider {
 					return os.Getenv("SEI_IDENTITY_API_URL"), nil
 				},
 			},
+			"grant_type": {
+				Type:     schema.TypeString,
+				Optional: true,
+				DefaultFunc: func() (interface{}, error) {
+					if grant := os.Getenv("SEI_IDENTITY_GRANT_TYPE"); grant != "" {
+						return grant, nil
+					}
+					return util.GrantClientCredentials, nil
+				},
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					str := value.(string)
+					if str != util.GrantClientCredentials && str != util.GrantPassword {
+						return nil, []error{fmt.Errorf("%s must be %q or %q", key, util.GrantClientCredentials, util.GrantPassword)}
+					}
+					return nil, nil
+				},
+			},
+			"max_retries": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  util.DefaultMaxRetries,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					if value.(int) < 0 {
+						return nil, []error{fmt.Errorf("%s cannot be negative", key)}
+					}
+					return nil, nil
+				},
+			},
+			// Seconds
+			"max_retry_wait": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  int(util.DefaultMaxWait / time.Second),
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					if value.(int) < 0 {
+						return nil, []error{fmt.Errorf("%s cannot be negative", key)}
+					}
+					return nil, nil
+				},
+			},
+			// Seconds
+			"request_timeout": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  int(util.DefaultRequestTimeout / time.Second),
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					if value.(int) < 0 {
+						return nil, []error{fmt.Errorf("%s cannot be negative", key)}
+					}
+					return nil, nil
+				},
+			},
+			"ca_cert_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"ca_cert"},
+				DefaultFunc: func() (interface{}, error) {
+					return os.Getenv("SEI_IDENTITY_CA_FILE"), nil
+				},
+			},
+			"ca_cert": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"ca_cert_file"},
+			},
+			"client_cert_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"client_cert"},
+				RequiredWith:  []string{"client_key_file"},
+			},
+			"client_key_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"client_key"},
+				RequiredWith:  []string{"client_cert_file"},
+			},
+			"client_cert": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"client_cert_file"},
+				RequiredWith:  []string{"client_key"},
+			},
+			"client_key": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Sensitive:     true,
+				ConflictsWith: []string{"client_key_file"},
+				RequiredWith:  []string{"client_cert"},
+			},
+			"tls_min_version": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  util.DefaultTLSMinVersion,
+				ValidateFunc: func(value interface{}, key string) ([]string, []error) {
+					if !util.ValidTLSVersion(value.(string)) {
+						return nil, []error{fmt.Errorf("%s must be one of 1.0, 1.1, 1.2, or 1.3", key)}
+					}
+					return nil, nil
+				},
+			},
+			// Only for lab environments where the server certificate can't be verified
+			"insecure_skip_verify": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			// Logs request and response bodies at the TRACE level. Sensitive values are still redacted.
+			"trace_http_bodies": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"scopes": {
+				Type:     schema.TypeString,
+				Optional: true,
+				DefaultFunc: func() (interface{}, error) {
+					if scopes := os.Getenv("SEI_IDENTITY_SCOPES"); scopes != "" {
+						return scopes, nil
+					}
+					return util.DefaultScopes, nil
+				},
+			},
+			// Account property keys that are never read into state, e.g. ones set by another system
+			"ignored_property_keys": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
 		},
-		ConfigureFunc: config,
+		ConfigureContextFunc: config,
 	}
 }
 
 // This will read in the key-value pairs supplied in the provider block of the config file.
-// The map that is returned can be accessed in the CRUD functions in a _server.go file via the m parameter.
-func config(r *schema.ResourceData) (interface{}, error) {
-	user := r.Get("username")
-	pass := r.Get("password")
-	idTok := r.Get("id_token_url")
-	id := r.Get("client_id")
-	sec := r.Get("client_secret")
-	idAPI := r.Get("id_api_url")
+// The API client that is returned can be accessed in the CRUD functions in a _server.go file via the m parameter.
+// It is shared by every resource so that connections and the bearer token are reused for the whole run.
+func config(ctx context.Context, r *schema.ResourceData) (interface{}, diag.Diagnostics) {
+	idTok := r.Get("id_token_url").(string)
+	id := r.Get("client_id").(string)
+	sec := r.Get("client_secret").(string)
+	idAPI := r.Get("id_api_url").(string)
+	grant := r.Get("grant_type").(string)
+	user := r.Get("username").(string)
+	pass := r.Get("password").(string)
+
+	// The SDK accepts an empty string for a required attribute, e.g. when the environment variable behind it is unset
+	missing := make([]string, 0)
+	for _, setting := range [][2]string{{"id_token_url", idTok}, {"client_id", id}, {"client_secret", sec}, {"id_api_url", idAPI}} {
+		if setting[1] == "" {
+			missing = append(missing, setting[0])
+		}
+	}
+	if len(missing) > 0 {
+		return nil, diag.Errorf("The provider is missing required settings: %v. Set them in the provider block or "+
+			"through their environment variables", strings.Join(missing, ", "))
+	}
 
-	if user == nil || pass == nil || id == nil || sec == nil || idAPI == nil || idTok == nil {
-		return nil, nil
+	if grant == util.GrantPassword && (user == "" || pass == "") {
+		return nil, diag.Errorf("username and password must be set when using the %q grant", util.GrantPassword)
 	}
 
-	m := make(map[string]string)
-	m["username"] = user.(string)
-	m["password"] = pass.(string)
-	m["id_token_url"] = idTok.(string)
-	m["client_id"] = id.(string)
-	m["client_secret"] = sec.(string)
-	m["id_api_url"] = idAPI.(string)
-	return m, nil
+	httpClient, err := util.NewHTTPClient(util.HTTPConfig{
+		Retry: util.RetryPolicy{
+			MaxRetries: r.Get("max_retries").(int),
+			MaxWait:    time.Duration(r.Get("max_retry_wait").(int)) * time.Second,
+		},
+		RequestTimeout: time.Duration(r.Get("request_timeout").(int)) * time.Second,
+		TraceBodies:    r.Get("trace_http_bodies").(bool),
+		TLS: util.TLSConfig{
+			CAFile:             r.Get("ca_cert_file").(string),
+			CAPEM:              r.Get("ca_cert").(string),
+			ClientCertFile:     r.Get("client_cert_file").(string),
+			ClientKeyFile:      r.Get("client_key_file").(string),
+			ClientCertPEM:      r.Get("client_cert").(string),
+			ClientKeyPEM:       r.Get("client_key").(string),
+			MinVersion:         r.Get("tls_min_version").(string),
+			InsecureSkipVerify: r.Get("insecure_skip_verify").(bool),
+		},
+	})
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+	tokens := util.NewTokenSource(util.TokenConfig{
+		TokenURL:     idTok,
+		ClientID:     id,
+		ClientSecret: sec,
+		GrantType:    grant,
+		Scopes:       r.Get("scopes").(string),
+		Username:     user,
+		Password:     pass,
+	}, httpClient)
+
+	client := api.NewClient(idAPI, tokens, httpClient)
+	for _, key := range r.Get("ignored_property_keys").([]interface{}) {
+		client.IgnoredPropertyKeys = append(client.IgnoredPropertyKeys, key.(string))
+	}
+	return client, nil
 }
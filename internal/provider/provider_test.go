@@ -0,0 +1,33 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestConfigureNamesMissingSettings(t *testing.T) {
+	for _, env := range []string{"SEI_IDENTITY_TOK_URL", "SEI_IDENTITY_CLIENT_ID", "SEI_IDENTITY_CLIENT_SECRET", "SEI_IDENTITY_API_URL"} {
+		t.Setenv(env, "")
+	}
+
+	diags := Provider().Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]interface{}{
+		"client_id": "terraform",
+	}))
+	if !diags.HasError() {
+		t.Fatal("expected an error for the missing settings")
+	}
+	summary := diags[0].Summary
+	for _, key := range []string{"id_token_url", "client_secret", "id_api_url"} {
+		if !strings.Contains(summary, key) {
+			t.Errorf("error %q does not name %v", summary, key)
+		}
+	}
+	if strings.Contains(summary, "client_id") {
+		t.Errorf("error %q names client_id, which is set", summary)
+	}
+}
@@ -0,0 +1,529 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package provider
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"identity_provider/internal/api"
+	"identity_provider/internal/structs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Formats a roster document can be written in
+const (
+	rosterFormatCSV  = "csv"
+	rosterFormatJSON = "json"
+)
+
+// Defaults for rosters that don't set a batch size or role
+const (
+	// Number of accounts created with a single request
+	defaultRosterBatchSize = 50
+	defaultRosterRole      = "Member"
+)
+
+// Rosters tracking more accounts than this are refreshed by paging through the account search, 100 accounts at a
+// time, rather than with a request per account
+const rosterSearchThreshold = 50
+
+// The search reads at most one page for every this many tracked accounts. Accounts it hasn't found by then are read
+// one at a time, so a refresh never costs much more than reading every account on its own, however many accounts
+// Identity has.
+const rosterAccountsPerSearchPage = 10
+
+// Settings that control how a roster is applied. Both rosters and cohorts have attributes with these names.
+type rosterOptions struct {
+	// Number of accounts created with a single request
+	batchSize int
+	// What to do with rows whose account already exists
+	onConflict string
+	// Whether accounts that are adopted get the password of their row
+	setAdoptedPasswords bool
+}
+
+// Read the roster options from a roster or cohort
+func rosterOptionsFrom(d *schema.ResourceData) rosterOptions {
+	return rosterOptions{
+		batchSize:           d.Get("batch_size").(int),
+		onConflict:          d.Get("on_conflict").(string),
+		setAdoptedPasswords: d.Get("set_adopted_passwords").(bool),
+	}
+}
+
+// One account in a roster. Name and email are written to the account's built-in properties.
+type rosterRow struct {
+	Username   string            `json:"username"`
+	Password   string            `json:"password"`
+	Role       string            `json:"role"`
+	Name       string            `json:"name"`
+	Email      string            `json:"email"`
+	Properties map[string]string `json:"properties"`
+}
+
+// Parse a roster document into its rows. Rows without a password or role get the defaults. Every row needs a
+// username, and each username can only appear once. The built-in properties can't be set as plain properties.
+func parseRoster(document, format, defaultPassword, defaultRole string) ([]*rosterRow, error) {
+	var rows []*rosterRow
+	var err error
+	switch format {
+	case rosterFormatCSV:
+		rows, err = parseRosterCSV(document)
+	case rosterFormatJSON:
+		err = json.Unmarshal([]byte(document), &rows)
+	default:
+		err = fmt.Errorf("unsupported roster format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i, row := range rows {
+		if row.Username == "" {
+			return nil, fmt.Errorf("row %d of the roster has no username", i+1)
+		}
+		if seen[strings.ToLower(row.Username)] {
+			return nil, fmt.Errorf("username %v appears more than once in the roster", row.Username)
+		}
+		seen[strings.ToLower(row.Username)] = true
+
+		if row.Password == "" {
+			row.Password = defaultPassword
+		}
+		if row.Password == "" {
+			return nil, fmt.Errorf("no password given for %v and there is no default password", row.Username)
+		}
+		if row.Role == "" {
+			row.Role = defaultRole
+		}
+
+		for key := range row.Properties {
+			if api.IsBuiltinProperty(key) {
+				return nil, fmt.Errorf("the properties of %v cannot use the built-in key %v. Set name and email on the row itself",
+					row.Username, key)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// Parse a CSV roster. The first line is a header naming the columns. The username, password, role, name and email
+// columns fill in those fields, and every other column is a property. Empty cells are skipped.
+func parseRosterCSV(document string) ([]*rosterRow, error) {
+	reader := csv.NewReader(strings.NewReader(document))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	rows := make([]*rosterRow, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := &rosterRow{Properties: make(map[string]string)}
+		for i, cell := range record {
+			cell = strings.TrimSpace(cell)
+			if cell == "" {
+				continue
+			}
+			switch column := strings.TrimSpace(header[i]); strings.ToLower(column) {
+			case "username":
+				row.Username = cell
+			case "password":
+				row.Password = cell
+			case "role":
+				row.Role = cell
+			case "name":
+				row.Name = cell
+			case "email":
+				row.Email = cell
+			default:
+				row.Properties[column] = cell
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Bring the accounts in Identity in line with a roster. oldRows is the roster as of the last apply and newRows is
+// the one being applied. The account_ids and global_ids attributes track which rows have an account, so rows
+// are only created, updated, or disabled when they changed or their account went missing.
+//
+// The tracked IDs are written back to the resource even if some rows fail, so the next apply picks up where
+// this one stopped. Rows whose account already exists are handled according to the options, as with identity_account.
+// Returns the usernames of the accounts that were adopted.
+func applyRoster(ctx context.Context, d *schema.ResourceData, oldRows, newRows []*rosterRow, opts rosterOptions, m *api.Client) ([]string, diag.Diagnostics) {
+	oldIDs, _ := d.GetChange("account_ids")
+	oldGlobalIDs, _ := d.GetChange("global_ids")
+	ids := stringMap(oldIDs.(map[string]interface{}))
+	globalIDs := stringMap(oldGlobalIDs.(map[string]interface{}))
+
+	adopted, diags := applyRosterRows(ctx, ids, globalIDs, oldRows, newRows, opts, m)
+
+	if err := d.Set("account_ids", ids); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+	}
+	if err := d.Set("global_ids", globalIDs); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+	}
+	return adopted, diags
+}
+
+func applyRosterRows(ctx context.Context, ids, globalIDs map[string]string, oldRows, newRows []*rosterRow, opts rosterOptions, m *api.Client) ([]string, diag.Diagnostics) {
+	oldByUser := rowsByUsername(oldRows)
+	newByUser := rowsByUsername(newRows)
+
+	// Disable the accounts of rows that were removed
+	for user, id := range ids {
+		if _, ok := newByUser[user]; ok {
+			continue
+		}
+		log.Printf("! Disabling account %v removed from roster", user)
+		err := api.DisableAccount(ctx, id, m)
+		if err != nil {
+			return nil, diag.FromErr(err)
+		}
+		delete(ids, user)
+		delete(globalIDs, user)
+	}
+
+	// Update rows that already have an account
+	toCreate := make([]*rosterRow, 0)
+	for _, row := range newRows {
+		id, ok := ids[row.Username]
+		if !ok {
+			toCreate = append(toCreate, row)
+			continue
+		}
+		if old, ok := oldByUser[row.Username]; ok {
+			err := updateRosterRow(ctx, id, old, row, m)
+			if err != nil {
+				return nil, diag.FromErr(err)
+			}
+		}
+	}
+
+	return createRosterRows(ctx, ids, globalIDs, toCreate, opts, m)
+}
+
+// Create accounts for the given rows. Rows with the same password and role are created together, opts.batchSize
+// at a time. Accounts that already existed are adopted with a warning if the conflict policy allows it. Rows it doesn't
+// allow are left without an account and only get a warning. An error would fail the whole apply, and on create taint
+// the roster, so every account it just created would be disabled and adopted again. Since these rows aren't
+// tracked, the next plan tries them again. Returns the usernames of the accounts that were adopted.
+func createRosterRows(ctx context.Context, ids, globalIDs map[string]string, rows []*rosterRow, opts rosterOptions, m *api.Client) ([]string, diag.Diagnostics) {
+	// Group the rows that can share a request
+	groups := make(map[[2]string][]*rosterRow)
+	groupKeys := make([][2]string, 0)
+	for _, row := range rows {
+		key := [2]string{row.Password, row.Role}
+		if _, ok := groups[key]; !ok {
+			groupKeys = append(groupKeys, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+
+	var diags diag.Diagnostics
+	adopted := make([]string, 0)
+	conflicts := make([]string, 0)
+	for _, key := range groupKeys {
+		group := groups[key]
+		for start := 0; start < len(group); start += opts.batchSize {
+			end := start + opts.batchSize
+			if end > len(group) {
+				end = len(group)
+			}
+			batch := group[start:end]
+
+			acct := &structs.Account{
+				Usernames: make([]string, 0, len(batch)),
+				Password:  key[0],
+				Role:      key[1],
+			}
+			for _, row := range batch {
+				acct.Usernames = append(acct.Usernames, row.Username)
+			}
+
+			created, exists, err := api.CreateAccounts(ctx, acct, m)
+			if err != nil {
+				return adopted, diag.FromErr(err)
+			}
+
+			for i, row := range batch {
+				if exists[i] {
+					err = allowAdoption(ctx, row.Username, opts.onConflict, m)
+					if err != nil {
+						conflicts = append(conflicts, err.Error())
+						continue
+					}
+				}
+				err = finishRosterRow(ctx, row, created[i], exists[i], opts.setAdoptedPasswords, m)
+				if err != nil {
+					return adopted, diag.FromErr(err)
+				}
+				ids[row.Username] = created[i].ID
+				globalIDs[row.Username] = created[i].GlobalID
+				if exists[i] {
+					adopted = append(adopted, row.Username)
+				}
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "Existing accounts were not adopted",
+			Detail: fmt.Sprintf("%v.\nThese rows have no account. They are tried again on the next apply, or change "+
+				"on_conflict to adopt the accounts.", strings.Join(conflicts, ".\n")),
+		})
+	}
+	if len(adopted) == 0 {
+		return adopted, diags
+	}
+	sort.Strings(adopted)
+	set := "role and properties were"
+	if opts.setAdoptedPasswords {
+		set = "password, role and properties were"
+	}
+	return adopted, append(diags, diag.Diagnostic{
+		Severity: diag.Warning,
+		Summary:  "Adopted existing accounts",
+		Detail: fmt.Sprintf("These accounts already existed in Identity and are now managed by Terraform: %v. "+
+			"They were enabled and their %v set from the roster.", strings.Join(adopted, ", "), set),
+	})
+}
+
+// Set up an account that was just created, or that already existed, for a roster row. An account that already
+// existed keeps its password unless setPassword is true, like identity_account does.
+func finishRosterRow(ctx context.Context, row *rosterRow, acct *structs.Account, existed, setPassword bool, m *api.Client) error {
+	// The API doesn't always send the IDs back
+	if acct.ID == "" || acct.GlobalID == "" {
+		id, glob, err := api.GetIDs(ctx, row.Username, m)
+		if err != nil {
+			return err
+		}
+		acct.ID = id
+		acct.GlobalID = glob
+	}
+
+	if existed {
+		err := api.EnableAccount(ctx, acct.ID, m)
+		if err != nil {
+			return err
+		}
+	}
+	if existed && setPassword {
+		err := api.SetPassword(ctx, acct.ID, row.Password, m)
+		if err != nil {
+			return err
+		}
+	}
+	if existed || !strings.EqualFold(acct.Role, row.Role) {
+		err := api.SetRole(ctx, acct.ID, row.Role, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := api.AddProperties(ctx, rowProperties(acct.ID, row.Properties, nil), m)
+	if err != nil {
+		return err
+	}
+	return api.AddProperties(ctx, rowProperties(acct.ID, rowBuiltins(row), nil), m)
+}
+
+// Push the changes between two versions of a row to its account
+func updateRosterRow(ctx context.Context, id string, old, curr *rosterRow, m *api.Client) error {
+	if old.Password != curr.Password {
+		err := api.SetPassword(ctx, id, curr.Password, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	if old.Role != curr.Role {
+		err := api.SetRole(ctx, id, curr.Role, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	removed := make(map[string]string)
+	for key, value := range old.Properties {
+		if _, ok := curr.Properties[key]; !ok {
+			removed[key] = value
+		}
+	}
+	err := api.RemoveProperties(ctx, rowProperties(id, removed, nil), m)
+	if err != nil {
+		return err
+	}
+
+	err = api.AddProperties(ctx, rowProperties(id, curr.Properties, old.Properties), m)
+	if err != nil {
+		return err
+	}
+	return api.AddProperties(ctx, rowProperties(id, rowBuiltins(curr), rowBuiltins(old)), m)
+}
+
+// Put the given attributes back to the values they had before this apply. Used when an update fails partway, so
+// the previous roster stays in state and the next plan tries the change again. Unlike d.Partial(true), this keeps
+// the tracked IDs written by applyRoster, so accounts created before the failure are not forgotten.
+func keepOldValues(d *schema.ResourceData, keys ...string) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for _, key := range keys {
+		old, _ := d.GetChange(key)
+		if err := d.Set(key, old); err != nil {
+			diags = append(diags, diag.FromErr(err)...)
+		}
+	}
+	return diags
+}
+
+// Disable every account tracked by a roster
+func disableRoster(ctx context.Context, d *schema.ResourceData, m *api.Client) error {
+	for user, id := range d.Get("account_ids").(map[string]interface{}) {
+		log.Printf("! Disabling roster account %v", user)
+		err := api.DisableAccount(ctx, id.(string), m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refresh the IDs tracked by a roster. Rows whose account no longer exists are dropped, so the next plan creates
+// them again. Large rosters are read with a paged search instead of a request per row, and only the accounts the
+// search didn't find are read on their own.
+func readRoster(ctx context.Context, d *schema.ResourceData, m *api.Client) error {
+	ids := stringMap(d.Get("account_ids").(map[string]interface{}))
+	globalIDs := stringMap(d.Get("global_ids").(map[string]interface{}))
+
+	found := make(map[string]*structs.Account)
+	if len(ids) > rosterSearchThreshold {
+		wanted := make([]string, 0, len(ids))
+		for _, id := range ids {
+			wanted = append(wanted, id)
+		}
+		var err error
+		found, err = api.FindAccountsByID(ctx, wanted, len(ids)/rosterAccountsPerSearchPage, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	for user, id := range ids {
+		acct, ok := found[id]
+		if !ok {
+			var err error
+			acct, err = api.ReadAccountByID(ctx, id, m)
+			if err != nil {
+				return err
+			}
+		}
+		if acct == nil {
+			delete(ids, user)
+			delete(globalIDs, user)
+			continue
+		}
+		globalIDs[user] = acct.GlobalID
+	}
+
+	err := d.Set("account_ids", ids)
+	if err != nil {
+		return err
+	}
+	return d.Set("global_ids", globalIDs)
+}
+
+// Plan an update of the tracked IDs, and of any other keys given, if the rows in the roster no longer line up with the accounts that exist,
+// either because rows were added or removed or because a read dropped a row whose account went missing
+func diffRoster(rows []*rosterRow, d *schema.ResourceDiff, keys ...string) error {
+	if d.Id() == "" {
+		return nil
+	}
+
+	ids := d.Get("account_ids").(map[string]interface{})
+	changed := len(ids) != len(rows)
+	for _, row := range rows {
+		if _, ok := ids[row.Username]; !ok {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	for _, key := range append([]string{"account_ids", "global_ids"}, keys...) {
+		err := d.SetNewComputed(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Build the properties to send for an account. Properties with the same value in skip are left out.
+func rowProperties(id string, props, skip map[string]string) *[]*structs.Property {
+	accID, _ := strconv.Atoi(id)
+
+	ret := new([]*structs.Property)
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if old, ok := skip[key]; ok && old == props[key] {
+			continue
+		}
+		*ret = append(*ret, &structs.Property{AccountID: accID, Key: key, Value: props[key]})
+	}
+	return ret
+}
+
+// The built-in properties set by a row. Like on identity_account, a name or email that is left out is not
+// written, so removing one from the roster leaves the account's value as it is.
+func rowBuiltins(row *rosterRow) map[string]string {
+	ret := make(map[string]string)
+	if row.Name != "" {
+		ret["name"] = row.Name
+	}
+	if row.Email != "" {
+		ret["email"] = row.Email
+	}
+	return ret
+}
+
+func rowsByUsername(rows []*rosterRow) map[string]*rosterRow {
+	ret := make(map[string]*rosterRow)
+	for _, row := range rows {
+		ret[row.Username] = row
+	}
+	return ret
+}
+
+func stringMap(generic map[string]interface{}) map[string]string {
+	ret := make(map[string]string)
+	for key, value := range generic {
+		ret[key] = value.(string)
+	}
+	return ret
+}
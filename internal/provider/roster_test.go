@@ -0,0 +1,248 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"identity_provider/internal/api"
+	"identity_provider/internal/structs"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// The fake Identity server of the account tests, extended for rosters. Accounts it is asked to create get IDs
+// counting up from 100. Usernames in taken get one too, but it says they already exist. A request to create any of
+// the usernames in broken fails with a 500. The search returns the accounts in searchable. Reads of any account
+// other than 42 by ID are counted, and answered with the account if the fake created it, or a 404. Changes to an
+// account always succeed, and the IDs of the accounts whose password is set are kept in passwordSets.
+type fakeRoster struct {
+	*fakeIdentity
+	taken      map[string]bool
+	broken     map[string]bool
+	searchable []map[string]interface{}
+
+	created      map[string]map[string]interface{}
+	passwordSets []string
+	nextID       int
+	reads        int
+}
+
+func newFakeRoster(t *testing.T) *fakeRoster {
+	t.Helper()
+	fake := &fakeRoster{fakeIdentity: newFakeIdentity(t), taken: make(map[string]bool), broken: make(map[string]bool),
+		created: make(map[string]map[string]interface{}), nextID: 100}
+
+	fake.mux.HandleFunc("/api/account", func(w http.ResponseWriter, r *http.Request) {
+		acct := structs.Account{}
+		if err := json.NewDecoder(r.Body).Decode(&acct); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		for _, user := range acct.Usernames {
+			if fake.broken[user] {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		results := make([]interface{}, 0, len(acct.Usernames))
+		for _, user := range acct.Usernames {
+			account := map[string]interface{}{
+				"id":       fake.nextID,
+				"globalId": fmt.Sprintf("global-%d", fake.nextID),
+				"role":     acct.Role,
+				"status":   api.StatusEnabled,
+				"properties": []interface{}{
+					map[string]interface{}{"accountId": fake.nextID, "key": "username", "value": user},
+				},
+			}
+			fake.created[strconv.Itoa(fake.nextID)] = account
+			fake.nextID++
+			if fake.taken[user] {
+				results = append(results, map[string]interface{}{
+					"id": account["id"], "globalId": account["globalId"], "message": "AccountNotUnique",
+				})
+				continue
+			}
+			results = append(results, account)
+		}
+		json.NewEncoder(w).Encode(results)
+	})
+	fake.mux.HandleFunc("/api/accounts", func(w http.ResponseWriter, r *http.Request) {
+		skip, _ := strconv.Atoi(r.URL.Query().Get("Skip"))
+		take, _ := strconv.Atoi(r.URL.Query().Get("Take"))
+		page := make([]map[string]interface{}, 0)
+		for i := skip; i < skip+take && i < len(fake.searchable); i++ {
+			page = append(page, fake.searchable[i])
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+	fake.mux.HandleFunc("/api/account/", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if r.Method != http.MethodGet {
+			if strings.HasSuffix(r.URL.Path, "/password") {
+				fake.passwordSets = append(fake.passwordSets, strings.Split(r.URL.Path, "/")[3])
+			}
+			return
+		}
+		fake.reads++
+		account, ok := fake.created[strings.TrimPrefix(r.URL.Path, "/api/account/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(account)
+	})
+
+	return fake
+}
+
+func TestRosterUpdateFailureKeepsOldRoster(t *testing.T) {
+	fake := newFakeRoster(t)
+	fake.broken["broken@range.example"] = true
+	client := fake.client()
+	resource := identityAccountRoster()
+
+	oldRoster := "username\nalice@range.example\n"
+	old := schema.TestResourceDataRaw(t, resource.Schema, map[string]interface{}{
+		"roster":           oldRoster,
+		"default_password": "Password",
+	})
+	old.SetId("roster")
+	old.Set("account_ids", map[string]interface{}{"alice@range.example": "42"})
+	old.Set("global_ids", map[string]interface{}{"alice@range.example": "8c1e0d36-0f6b-4a4e-9a4a-5f1d4d1b2c3a"})
+	state := old.State()
+
+	// The broken row has its own role, so it is created after bob in a request of its own
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		"roster":           "username,role\nalice@range.example,\nbob@range.example,\nbroken@range.example,Admin\n",
+		"default_password": "Password",
+	})
+	diff, err := resource.Diff(context.Background(), state, config, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newState, diags := resource.Apply(context.Background(), state, diff, client)
+	if !diags.HasError() {
+		t.Fatal("expected the broken account to fail the apply")
+	}
+
+	// The roster goes back to the old one so the change is planned again, but bob's new account stays tracked
+	if got := newState.Attributes["roster"]; got != oldRoster {
+		t.Errorf("got roster %q in state, want the old roster %q", got, oldRoster)
+	}
+	if got := newState.Attributes["account_ids.bob@range.example"]; got != "100" {
+		t.Errorf("got account ID %q for bob, want 100", got)
+	}
+	if got, ok := newState.Attributes["account_ids.broken@range.example"]; ok {
+		t.Errorf("got account ID %q for the broken account, want none", got)
+	}
+}
+
+func TestRosterCreateConflictIsWarning(t *testing.T) {
+	fake := newFakeRoster(t)
+	fake.taken["taken@range.example"] = true
+	client := fake.client()
+	resource := identityAccountRoster()
+
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		"roster":           "username\nbob@range.example\ntaken@range.example\n",
+		"default_password": "Password",
+		"on_conflict":      onConflictFail,
+	})
+	diff, err := resource.Diff(context.Background(), nil, config, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newState, diags := resource.Apply(context.Background(), nil, diff, client)
+	// An error here would taint the roster, so the next apply would disable and recreate bob's account too
+	if diags.HasError() {
+		t.Fatalf("a conflict failed the create: %v", diags)
+	}
+	if len(diags) == 0 || diags[0].Severity != diag.Warning {
+		t.Errorf("got diagnostics %v, want a warning about the taken account", diags)
+	}
+	if got := newState.Attributes["account_ids.bob@range.example"]; got != "100" {
+		t.Errorf("got account ID %q for bob, want 100", got)
+	}
+	if got, ok := newState.Attributes["account_ids.taken@range.example"]; ok {
+		t.Errorf("got account ID %q for the taken account, want none", got)
+	}
+}
+
+func TestParseRosterBuiltins(t *testing.T) {
+	rows, err := parseRoster("username,Name,email,team\nalice@range.example,Alice,alice@mail.example,blue\n",
+		rosterFormatCSV, "Password", defaultRosterRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := rows[0]
+	if row.Name != "Alice" || row.Email != "alice@mail.example" {
+		t.Errorf("got name %q and email %q, want them read from their columns", row.Name, row.Email)
+	}
+	if len(row.Properties) != 1 || row.Properties["team"] != "blue" {
+		t.Errorf("got properties %v, want only team", row.Properties)
+	}
+
+	_, err = parseRoster(`[{"username": "alice@range.example", "properties": {"email": "alice@mail.example"}}]`,
+		rosterFormatJSON, "Password", defaultRosterRole)
+	if err == nil {
+		t.Error("expected a built-in key in the properties to be rejected")
+	}
+}
+
+func TestReadLargeRosterWithSearch(t *testing.T) {
+	fake := newFakeRoster(t)
+	client := fake.client()
+	resource := identityAccountRoster()
+
+	ids := make(map[string]interface{})
+	for i := 0; i < rosterSearchThreshold+10; i++ {
+		user := fmt.Sprintf("user-%d@range.example", i)
+		ids[user] = strconv.Itoa(1000 + i)
+		// The last account was deleted, so the search doesn't return it
+		if i == rosterSearchThreshold+9 {
+			continue
+		}
+		fake.searchable = append(fake.searchable, map[string]interface{}{
+			"id":       float64(1000 + i),
+			"globalId": fmt.Sprintf("global-%d", 1000+i),
+			"role":     "Member",
+			"status":   api.StatusEnabled,
+		})
+	}
+
+	d := schema.TestResourceDataRaw(t, resource.Schema, map[string]interface{}{"roster": "username\n"})
+	d.SetId("roster")
+	d.Set("account_ids", ids)
+
+	diags := identityAccountRosterRead(context.Background(), d, client)
+	if diags.HasError() {
+		t.Fatalf("read failed: %v", diags)
+	}
+
+	// Only the account missing from the search is read on its own
+	if fake.reads != 1 {
+		t.Errorf("got %d reads by ID, want 1", fake.reads)
+	}
+	got := d.Get("account_ids").(map[string]interface{})
+	if len(got) != rosterSearchThreshold+9 {
+		t.Errorf("got %d tracked accounts, want %d", len(got), rosterSearchThreshold+9)
+	}
+	if _, ok := got[fmt.Sprintf("user-%d@range.example", rosterSearchThreshold+9)]; ok {
+		t.Error("the deleted account is still tracked")
+	}
+	if globalIDs := d.Get("global_ids").(map[string]interface{}); globalIDs["user-0@range.example"] != "global-1000" {
+		t.Errorf("got global ID %v for user-0, want global-1000", globalIDs["user-0@range.example"])
+	}
+}
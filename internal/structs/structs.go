@@ -16,6 +16,9 @@ type Account struct {
 	ID         string
 	GlobalID   string
 	Properties []Property
+	// Name and Email come from the built-in properties, which are not part of the account sent to the API
+	Name  string `json:"-"`
+	Email string `json:"-"`
 }
 
 // Property holds the info on a property within an account
@@ -0,0 +1,146 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// Placeholder logged in place of sensitive values
+const redacted = "[REDACTED]"
+
+// Keys whose values are never written to the log, wherever they appear in a body
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"client_secret": true,
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+}
+
+// Lists on a client whose entries have a value that is safe to log. Every other "value" field belongs to a
+// property or a secret and is redacted.
+var publicValueLists = map[string]bool{
+	"redirecturls":   true,
+	"corsurls":       true,
+	"postlogouturls": true,
+	"claims":         true,
+}
+
+// Transport that logs every request sent to the Identity server through the Terraform logger. Bodies are only
+// logged if traceBodies is set, and sensitive values in them are always redacted.
+type loggingTransport struct {
+	base        http.RoundTripper
+	traceBodies bool
+}
+
+func (t *loggingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+	fields := map[string]interface{}{
+		"method": request.Method,
+		"path":   request.URL.Path,
+	}
+
+	if t.traceBodies && request.GetBody != nil {
+		body, err := request.GetBody()
+		if err == nil {
+			contents, _ := io.ReadAll(body)
+			body.Close()
+			tflog.Trace(ctx, "Identity API request body", map[string]interface{}{
+				"method": request.Method,
+				"path":   request.URL.Path,
+				"body":   RedactBody(request.Header.Get("Content-Type"), contents),
+			})
+		}
+	}
+
+	start := time.Now()
+	response, err := t.base.RoundTrip(request)
+	fields["latency_ms"] = time.Since(start).Milliseconds()
+
+	if err != nil {
+		fields["error"] = err.Error()
+		tflog.Debug(ctx, "Identity API request failed", fields)
+		return response, err
+	}
+
+	fields["status"] = response.StatusCode
+	tflog.Debug(ctx, "Identity API request", fields)
+
+	if t.traceBodies {
+		contents, readErr := io.ReadAll(response.Body)
+		response.Body.Close()
+		response.Body = io.NopCloser(bytes.NewReader(contents))
+		if readErr == nil {
+			tflog.Trace(ctx, "Identity API response body", map[string]interface{}{
+				"method": request.Method,
+				"path":   request.URL.Path,
+				"status": response.StatusCode,
+				"body":   RedactBody(response.Header.Get("Content-Type"), contents),
+			})
+		}
+	}
+
+	return response, nil
+}
+
+// RedactBody returns a copy of a request or response body that is safe to log. Tokens, passwords, client
+// secrets and property values are replaced with a placeholder. Bodies that can't be parsed are not logged.
+func RedactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return redacted
+		}
+		for key := range values {
+			if sensitiveKeys[strings.ToLower(key)] {
+				values.Set(key, redacted)
+			}
+		}
+		return values.Encode()
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return redacted
+	}
+	asJSON, err := json.Marshal(redactValue(parsed, ""))
+	if err != nil {
+		return redacted
+	}
+	return string(asJSON)
+}
+
+// Walk a decoded JSON value and redact anything sensitive. parent is the key the value was found under.
+func redactValue(value interface{}, parent string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range v {
+			lower := strings.ToLower(key)
+			if sensitiveKeys[lower] || (lower == "value" && !publicValueLists[parent]) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactValue(inner, lower)
+		}
+		return v
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = redactValue(inner, parent)
+		}
+		return v
+	}
+	return value
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package util
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	const form = "application/x-www-form-urlencoded"
+	const js = "application/json; charset=utf-8"
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		hidden      []string
+		visible     []string
+	}{
+		{"token request", form,
+			"grant_type=password&client_id=terraform&client_secret=s3cret&username=admin&password=hunter2",
+			[]string{"s3cret", "hunter2"},
+			[]string{"client_id=terraform", "username=admin", "grant_type=password"}},
+		{"token response", js,
+			`{"access_token":"eyJhbGciOi","refresh_token":"r3fresh","id_token":"1dtoken","expires_in":3600,"token_type":"Bearer"}`,
+			[]string{"eyJhbGciOi", "r3fresh", "1dtoken"},
+			[]string{`"expires_in":3600`, `"token_type":"Bearer"`}},
+		{"account create", js,
+			`{"Usernames":["alice@range.example"],"Password":"hunter2","Role":"Member"}`,
+			[]string{"hunter2"},
+			[]string{"alice@range.example", `"Role":"Member"`}},
+		{"client secrets", js,
+			`{"Name":"player","Secrets":[{"ID":0,"Value":"s3cret","Deleted":false}]}`,
+			[]string{"s3cret"},
+			[]string{`"Name":"player"`}},
+		{"property", js,
+			`{"accountId":42,"key":"team","value":"blue"}`,
+			[]string{"blue"},
+			[]string{`"key":"team"`, `"accountId":42`}},
+		{"account properties", js,
+			`[{"id":42,"properties":[{"key":"badge","value":"1234"}]}]`,
+			[]string{"1234"},
+			[]string{`"key":"badge"`}},
+		{"client urls and claims", js,
+			`{"RedirectURLs":[{"Value":"https://player.example/cb"}],"CorsURLs":[{"Value":"https://player.example"}],` +
+				`"PostLogoutURLs":[{"Value":"https://player.example/out"}],"Claims":[{"Value":"role"}]}`,
+			nil,
+			[]string{"https://player.example/cb", "https://player.example/out", `"Value":"https://player.example"`, `"Value":"role"`}},
+	}
+
+	for _, test := range tests {
+		got := RedactBody(test.contentType, []byte(test.body))
+		for _, secret := range test.hidden {
+			if strings.Contains(got, secret) {
+				t.Errorf("%s: %q was logged in %v", test.name, secret, got)
+			}
+		}
+		for _, public := range test.visible {
+			if !strings.Contains(got, public) {
+				t.Errorf("%s: %q is missing from %v", test.name, public, got)
+			}
+		}
+		// Form bodies are encoded again after redacting, which escapes the brackets of the placeholder
+		if len(test.hidden) > 0 && !strings.Contains(got, redacted) && !strings.Contains(got, url.QueryEscape(redacted)) {
+			t.Errorf("%s: no value was redacted in %v", test.name, got)
+		}
+	}
+}
+
+func TestRedactBodyUnparseable(t *testing.T) {
+	if got := RedactBody("application/json", []byte(`{"Password":"hunter2"`)); got != redacted {
+		t.Errorf("got %q for a body that isn't JSON, want it redacted entirely", got)
+	}
+	if got := RedactBody("application/json", nil); got != "" {
+		t.Errorf("got %q for an empty body, want nothing", got)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package util
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// DefaultPasswordCharset is used for generated passwords when no other characters are given
+const DefaultPasswordCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*-_=+"
+
+// GeneratePassword returns a random password of the given length made of characters from charset. The characters
+// are picked with crypto/rand.
+func GeneratePassword(length int, charset string) (string, error) {
+	chars := []rune(charset)
+	if len(chars) == 0 {
+		return "", fmt.Errorf("cannot generate a password from an empty set of characters")
+	}
+
+	ret := make([]rune, length)
+	max := big.NewInt(int64(len(chars)))
+	for i := range ret {
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		ret[i] = chars[index.Int64()]
+	}
+	return string(ret), nil
+}
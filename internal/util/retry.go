@@ -0,0 +1,156 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package util
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default values for the retry policy when the provider block does not set them
+const (
+	DefaultMaxRetries = 4
+	DefaultMaxWait    = 30 * time.Second
+	minRetryWait      = 500 * time.Millisecond
+)
+
+// RetryPolicy controls how requests that fail for transient reasons are retried
+type RetryPolicy struct {
+	// MaxRetries is how many times a request is retried after the first attempt. Zero disables retries.
+	MaxRetries int
+	// MaxWait caps how long we wait before any single retry, including waits requested through Retry-After
+	MaxWait time.Duration
+}
+
+type retryKey struct{}
+
+// WithRetry marks requests made with the returned context as safe (or unsafe) to retry, overriding the default
+// that is picked based on the request method. Use this for calls that are not idempotent despite their method,
+// or for POSTs that can safely be repeated.
+func WithRetry(ctx context.Context, retry bool) context.Context {
+	return context.WithValue(ctx, retryKey{}, retry)
+}
+
+// Transport that retries transient failures with exponential backoff and jitter
+type retryTransport struct {
+	base   http.RoundTripper
+	policy RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		// Each retry gets its own copy of the request with a fresh body
+		current := request
+		if attempt > 0 && request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			current = request.Clone(request.Context())
+			current.Body = body
+		}
+
+		response, err := t.base.RoundTrip(current)
+		if attempt >= t.policy.MaxRetries || !shouldRetry(request, response, err) {
+			return response, err
+		}
+
+		wait := t.backoff(attempt, response)
+		if response != nil {
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-request.Context().Done():
+			timer.Stop()
+			return nil, request.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Figure out how long to wait before the next attempt. Honors Retry-After if the server sent one, otherwise
+// doubles the wait each attempt and picks a random point in the upper half of that window.
+func (t *retryTransport) backoff(attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		if wait, ok := retryAfter(response); ok {
+			if wait > t.policy.MaxWait {
+				return t.policy.MaxWait
+			}
+			return wait
+		}
+	}
+
+	wait := minRetryWait << uint(attempt)
+	if wait <= 0 || wait > t.policy.MaxWait {
+		wait = t.policy.MaxWait
+	}
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Whether a failed attempt is worth repeating. Idempotent requests are retried on network errors and on the
+// statuses a proxy or restarting server returns. Other requests are only retried when the server tells us it
+// did not process them.
+func shouldRetry(request *http.Request, response *http.Response, err error) bool {
+	if request.Context().Err() != nil {
+		return false
+	}
+	// Can't send the same body twice
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return false
+	}
+
+	safe := isIdempotent(request.Method)
+	if override, ok := request.Context().Value(retryKey{}).(bool); ok {
+		safe = override
+	}
+
+	if err != nil {
+		return safe
+	}
+
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return safe
+	}
+	return false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Parse the Retry-After header, which is either a number of seconds or an HTTP date
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	header := response.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,233 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Server that answers with the given statuses in order, then 200 for every request after that. Returns the server
+// and a counter of the requests it got.
+func statusServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&count, 1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func testTransport(maxRetries int, maxWait time.Duration) *retryTransport {
+	return &retryTransport{
+		base:   http.DefaultTransport,
+		policy: RetryPolicy{MaxRetries: maxRetries, MaxWait: maxWait},
+	}
+}
+
+func send(t *testing.T, transport http.RoundTripper, request *http.Request) (*http.Response, error) {
+	t.Helper()
+	response, err := transport.RoundTrip(request)
+	if response != nil {
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+	}
+	return response, err
+}
+
+func TestRetryTransientStatuses(t *testing.T) {
+	server, count := statusServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	response, err := send(t, testTransport(4, 10*time.Millisecond), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want 200", response.StatusCode)
+	}
+	if n := atomic.LoadInt32(count); n != 3 {
+		t.Errorf("got %d attempts, want 3", n)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	transport := testTransport(4, time.Hour)
+	response := &http.Response{Header: http.Header{"Retry-After": []string{"2"}}}
+	if wait := transport.backoff(0, response); wait != 2*time.Second {
+		t.Errorf("got wait %v, want 2s", wait)
+	}
+
+	transport.policy.MaxWait = time.Second
+	if wait := transport.backoff(0, response); wait != time.Second {
+		t.Errorf("got wait %v, want it capped at 1s", wait)
+	}
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	transport := testTransport(4, time.Hour)
+	date := time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)
+	response := &http.Response{Header: http.Header{"Retry-After": []string{date}}}
+	if wait := transport.backoff(0, response); wait < 80*time.Second || wait > 90*time.Second {
+		t.Errorf("got wait %v, want about 90s", wait)
+	}
+
+	transport.policy.MaxWait = 5 * time.Second
+	if wait := transport.backoff(0, response); wait != 5*time.Second {
+		t.Errorf("got wait %v, want it capped at 5s", wait)
+	}
+}
+
+func TestRetryAfterCappedByMaxWait(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	response, err := send(t, testTransport(4, 20*time.Millisecond), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want 200", response.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waited %v, Retry-After should have been capped by MaxWait", elapsed)
+	}
+}
+
+func TestNoRetryPostOnGatewayErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadGateway, http.StatusGatewayTimeout} {
+		server, count := statusServer(t, status)
+
+		request, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("{}"))
+		response, err := send(t, testTransport(4, 10*time.Millisecond), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != status {
+			t.Errorf("got status %d, want %d", response.StatusCode, status)
+		}
+		if n := atomic.LoadInt32(count); n != 1 {
+			t.Errorf("POST got %d attempts on %d, want 1", n, status)
+		}
+	}
+}
+
+func TestNoRetryPostOnNetworkError(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		// Drop the connection without answering
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	request, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("{}"))
+	_, err := send(t, testTransport(4, 10*time.Millisecond), request)
+	if err == nil {
+		t.Fatal("expected a network error")
+	}
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Errorf("POST got %d attempts on a network error, want 1", n)
+	}
+}
+
+func TestMaxRetriesZero(t *testing.T) {
+	server, count := statusServer(t, http.StatusServiceUnavailable)
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	response, err := send(t, testTransport(0, 10*time.Millisecond), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want 503", response.StatusCode)
+	}
+	if n := atomic.LoadInt32(count); n != 1 {
+		t.Errorf("got %d attempts, want 1", n)
+	}
+}
+
+func TestCancelDuringBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	_, err := send(t, testTransport(4, time.Minute), request)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waited %v after the context was cancelled", elapsed)
+	}
+}
+
+func TestRetryResendsBody(t *testing.T) {
+	var count int32
+	bodies := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	payload := `{"key":"team","value":"blue"}`
+	request, _ := http.NewRequest(http.MethodPut, server.URL, bytes.NewReader([]byte(payload)))
+	response, err := send(t, testTransport(4, 10*time.Millisecond), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want 200", response.StatusCode)
+	}
+
+	close(bodies)
+	n := 0
+	for body := range bodies {
+		n++
+		if body != payload {
+			t.Errorf("attempt %d sent body %q, want %q", n, body, payload)
+		}
+	}
+	if n != 2 {
+		t.Errorf("got %d attempts, want 2", n)
+	}
+}
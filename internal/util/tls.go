@@ -0,0 +1,112 @@
+// Copyright 2021 Carnegie Mellon University. All Rights Reserved.
+// Released under a MIT (SEI)-style license. See LICENSE.md in the project root for license information.
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLS versions that can be given as the minimum version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// DefaultTLSMinVersion is used when the provider block does not set a minimum TLS version
+const DefaultTLSMinVersion = "1.2"
+
+// TLSConfig holds the TLS settings used for both the token endpoint and the API. The CA bundle and client
+// certificate can each be given either as a file or as a PEM encoded string. CAPEM takes precedence over CAFile.
+type TLSConfig struct {
+	CAFile string
+	CAPEM  string
+
+	ClientCertFile string
+	ClientKeyFile  string
+	ClientCertPEM  string
+	ClientKeyPEM   string
+
+	// MinVersion is one of "1.0", "1.1", "1.2", or "1.3"
+	MinVersion string
+	// InsecureSkipVerify turns off verification of the server certificate. Only meant for lab use.
+	InsecureSkipVerify bool
+}
+
+// ValidTLSVersion returns whether the given string names a TLS version we can use as a minimum
+func ValidTLSVersion(version string) bool {
+	_, ok := tlsVersions[version]
+	return ok
+}
+
+// Build returns the crypto/tls configuration described by these settings
+func (config TLSConfig) Build() (*tls.Config, error) {
+	version := config.MinVersion
+	if version == "" {
+		version = DefaultTLSMinVersion
+	}
+	minVersion, ok := tlsVersions[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported minimum TLS version %q", config.MinVersion)
+	}
+
+	ret := &tls.Config{
+		MinVersion:         minVersion,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	// Trust the system roots plus whatever bundle was given. The file can come from the environment, so a PEM string
+	// set in the config wins over it.
+	caPEM := []byte(config.CAPEM)
+	if len(caPEM) == 0 && config.CAFile != "" {
+		contents, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle: %v", err)
+		}
+		caPEM = contents
+	}
+	if len(caPEM) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates could be parsed from the CA bundle")
+		}
+		ret.RootCAs = pool
+	}
+
+	certPEM := []byte(config.ClientCertPEM)
+	keyPEM := []byte(config.ClientKeyPEM)
+	if config.ClientCertFile != "" {
+		contents, err := os.ReadFile(config.ClientCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client certificate: %v", err)
+		}
+		certPEM = contents
+	}
+	if config.ClientKeyFile != "" {
+		contents, err := os.ReadFile(config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client key: %v", err)
+		}
+		keyPEM = contents
+	}
+
+	if len(certPEM) > 0 || len(keyPEM) > 0 {
+		if len(certPEM) == 0 || len(keyPEM) == 0 {
+			return nil, fmt.Errorf("a client certificate and key must be given together")
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		ret.Certificates = []tls.Certificate{cert}
+	}
+
+	return ret, nil
+}
@@ -3,58 +3,194 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 )
 
-// GetIdenAuth authenticates with the Identity API. Will probably want to modify this to handle the
-// first part in seed-data but this is fine for now
-func GetIdenAuth(m map[string]string) (string, error) {
-	// Call token endpoint
-	apiURL := m["id_token_url"]
+// Grant types that can be used to authenticate with the Identity token endpoint
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+)
+
+// DefaultScopes are the scopes requested when the provider block does not override them
+const DefaultScopes = "identity-api identity-api-privileged"
+
+// How long before a token's reported expiry we consider it stale and fetch a new one
+const tokenExpirySkew = 30 * time.Second
+
+// How long a token is assumed to be valid for when the token endpoint doesn't say. If the token runs out earlier,
+// the API rejects it and a new one is requested.
+const defaultTokenLifetime = 5 * time.Minute
+
+// TokenSource hands out bearer tokens for the Identity API. Tokens are cached and reused across every
+// API call in a run, and are only requested again once they are close to expiring or have been rejected.
+type TokenSource struct {
+	config     TokenConfig
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// TokenConfig holds the settings used to authenticate with the Identity token endpoint
+type TokenConfig struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// GrantType is either GrantClientCredentials or GrantPassword
+	GrantType string
+	// Scopes is a space separated list of scopes to request
+	Scopes string
+	// Username and Password are only sent when using the resource owner password grant
+	Username string
+	Password string
+}
+
+// NewTokenSource returns a token source that authenticates using the given configuration. Token requests are
+// sent through httpClient.
+func NewTokenSource(config TokenConfig, httpClient *http.Client) *TokenSource {
+	return &TokenSource{
+		config:     config,
+		httpClient: httpClient,
+	}
+}
+
+// DefaultRequestTimeout is how long we wait for the Identity server to respond when the provider block does not
+// say otherwise
+const DefaultRequestTimeout = 60 * time.Second
+
+// HTTPConfig holds the settings for the HTTP client used to talk to the Identity server
+type HTTPConfig struct {
+	Retry RetryPolicy
+	TLS   TLSConfig
+	// TraceBodies logs the (redacted) body of every request and response at the TRACE level
+	TraceBodies bool
+	// RequestTimeout limits how long we wait for the server to respond to a single attempt. Zero means no limit.
+	RequestTimeout time.Duration
+}
+
+// NewHTTPClient returns the HTTP client shared by the token source and the API client. Connections to the
+// Identity server are kept alive and reused between calls, transient failures are retried, and every attempt is
+// logged.
+func NewHTTPClient(config HTTPConfig) (*http.Client, error) {
+	tlsConfig, err := config.TLS.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 16
+	transport.ResponseHeaderTimeout = config.RequestTimeout
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: &retryTransport{
+			base: &loggingTransport{
+				base:        transport,
+				traceBodies: config.TraceBodies,
+			},
+			policy: config.Retry,
+		},
+	}, nil
+}
+
+// Token returns a valid bearer token, authenticating with the Identity API if the cached one is missing or stale
+func (ts *TokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && time.Now().Before(ts.expiry) {
+		return ts.token, nil
+	}
+
+	token, expiresIn, err := ts.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if expiresIn <= 0 {
+		expiresIn = defaultTokenLifetime
+	}
+
+	// Refresh shortly before the token actually expires. Very short lifetimes just get cut in half.
+	lifetime := expiresIn - tokenExpirySkew
+	if lifetime <= 0 {
+		lifetime = expiresIn / 2
+	}
+
+	ts.token = token
+	ts.expiry = time.Now().Add(lifetime)
+	return ts.token, nil
+}
+
+// Invalidate discards the cached token so the next call to Token authenticates again. This is used when the API
+// rejects a token that we believed to still be valid.
+func (ts *TokenSource) Invalidate() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.token = ""
+	ts.expiry = time.Time{}
+}
+
+// Call the token endpoint. Returns the access token and how long it is valid for.
+func (ts *TokenSource) requestToken(ctx context.Context) (string, time.Duration, error) {
 	resource := "connect/token"
 	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
-	data.Set("client_id", m["client_id"])
-	data.Set("client_secret", m["client_secret"])
-	data.Set("scope", "identity-api identity-api-privileged")
+	data.Set("grant_type", ts.config.GrantType)
+	data.Set("client_id", ts.config.ClientID)
+	data.Set("client_secret", ts.config.ClientSecret)
+	data.Set("scope", ts.config.Scopes)
+	if ts.config.GrantType == GrantPassword {
+		data.Set("username", ts.config.Username)
+		data.Set("password", ts.config.Password)
+	}
 
-	u, err := url.ParseRequestURI(apiURL)
+	u, err := url.ParseRequestURI(ts.config.TokenURL)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	u.Path = resource
 	urlStr := u.String()
 
-	request, err := http.NewRequest(http.MethodPost, urlStr, strings.NewReader(data.Encode()))
+	// Asking for another token has no side effects, so this POST can be retried like a GET
+	request, err := http.NewRequestWithContext(WithRetry(ctx, true), http.MethodPost, urlStr, strings.NewReader(data.Encode()))
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := ts.httpClient.Do(request)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
+	defer response.Body.Close()
 
 	status := response.StatusCode
 	if status != http.StatusOK {
-		return "", fmt.Errorf("Identity API returned with status %d when getting bearer token", status)
+		return "", 0, fmt.Errorf("Identity API returned with status %d when getting bearer token", status)
 	}
 
-	// Read body of response to find the token
-	body := make(map[string]interface{})
+	// Read body of response to find the token and its lifetime
+	body := struct {
+		AccessToken string  `json:"access_token"`
+		ExpiresIn   float64 `json:"expires_in"`
+	}{}
 	err = json.NewDecoder(response.Body).Decode(&body)
-	defer response.Body.Close()
-
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+	if body.AccessToken == "" {
+		return "", 0, fmt.Errorf("Identity API did not return an access token")
 	}
 
-	return body["access_token"].(string), nil
+	return body.AccessToken, time.Duration(body.ExpiresIn) * time.Second, nil
 }
-